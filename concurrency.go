@@ -0,0 +1,146 @@
+// Модуль ограничения параллельной обработки запросов микросервисом.
+//
+// Число одновременно выполняемых обработчиков ограничено. Пока все обработчики заняты,
+// новые запросы не извлекаются из очереди и не квитируются, поэтому брокер передает сервису
+// не более Prefetch запросов, а остальные остаются в очереди.
+//
+// Запрос команды с собственным ограничением (Concurrency.CommandLimits) ожидает
+// освобождения обработчика команды, не занимая общего обработчика, поэтому запросы
+// других команд продолжают выполняться.
+
+package microservice
+
+import (
+	"context"
+
+	"github.com/streadway/amqp"
+)
+
+// Concurrency описывает ограничения параллельной обработки запросов сервисом.
+type Concurrency struct {
+	// число одновременно выполняемых обработчиков
+	MaxInFlight int `json:"max_in_flight" yaml:"max_in_flight" toml:"max_in_flight"`
+	// число неквитированных запросов, получаемых от брокера
+	Prefetch int `json:"prefetch" yaml:"prefetch" toml:"prefetch"`
+	// число одновременно выполняемых обработчиков команды
+	CommandLimits map[string]int `json:"command_limits" yaml:"command_limits" toml:"command_limits"`
+}
+
+// DefaultConcurrency - умолчательные ограничения, соответствующие последовательной
+// обработке запросов.
+var DefaultConcurrency = Concurrency{MaxInFlight: 1, Prefetch: 1}
+
+// normalized возвращает ограничения с заполненными умолчательными значениями.
+// Prefetch по умолчанию совпадает с MaxInFlight.
+func (c Concurrency) normalized() Concurrency {
+	if c.MaxInFlight <= 0 {
+		c.MaxInFlight = DefaultConcurrency.MaxInFlight
+	}
+	if c.Prefetch <= 0 {
+		c.Prefetch = c.MaxInFlight
+	}
+	return c
+}
+
+// SetConcurrency задает ограничения параллельной обработки запросов.
+// Должен вызываться до подключения к брокеру сообщений.
+func (s *Service) SetConcurrency(c Concurrency) {
+	s.cfg.Concurrency = c.normalized()
+}
+
+// initSlots создает семафоры обработчиков в соответствии с ограничениями.
+func (s *Service) initSlots() {
+	s.cfg.Concurrency = s.cfg.Concurrency.normalized()
+	s.slots = make(chan struct{}, s.cfg.Concurrency.MaxInFlight)
+	s.cmdSlots = map[string]chan struct{}{}
+	for cmd, limit := range s.cfg.Concurrency.CommandLimits {
+		if limit > 0 {
+			s.cmdSlots[cmd] = make(chan struct{}, limit)
+		}
+	}
+}
+
+// acquire ожидает освобождения обработчика. Возвращает false, если ожидание прервано
+// отменой контекста, началом остановки или закрытием сервиса.
+func (s *Service) acquire(ctx context.Context) bool {
+	if s.isStopping() {
+		return false
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+	case <-s.stopping:
+	case <-s.done:
+	}
+	return false
+}
+
+func (s *Service) release() {
+	<-s.slots
+}
+
+// slot описывает общий обработчик, занятый запросом (см. acquire).
+type slot struct {
+	held bool
+}
+
+// start регистрирует запрос, дожидается освобождения обработчика и выполняет `f`
+// в отдельной go-процедуре. Если ожидание прервано остановкой сервиса, запрос
+// возвращается в очередь. По завершении `f` обработчик освобождается, если он занят.
+func (s *Service) start(ctx context.Context, delivery *amqp.Delivery, f func(sl *slot)) {
+	if !s.track(delivery) {
+		s.LogOnError(delivery.Nack(false, true))
+		return
+	}
+	if !s.acquire(ctx) {
+		if s.untrack(delivery) {
+			s.LogOnError(delivery.Nack(false, true))
+		}
+		return
+	}
+	sl := &slot{held: true}
+	go func() {
+		defer s.untrack(delivery)
+		defer func() {
+			if sl.held {
+				s.release()
+			}
+		}()
+		f(sl)
+	}()
+}
+
+// acquireCmd ожидает освобождения обработчика команды `cmd` и возвращает функцию его
+// освобождения. Вызывается запросом, занявшим общий обработчик `sl`. На время ожидания
+// общий обработчик освобождается, чтобы запросы других команд не простаивали, и
+// занимается снова до возврата. Возвращает false, если ожидание прервано отменой
+// контекста, началом остановки или закрытием сервиса; общий обработчик в этом случае
+// не занят.
+func (s *Service) acquireCmd(ctx context.Context, cmd string, sl *slot) (func(), bool) {
+	slots, ok := s.cmdSlots[cmd]
+	if !ok {
+		return func() {}, true
+	}
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, true
+	default:
+	}
+	s.release()
+	sl.held = false
+	select {
+	case slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, false
+	case <-s.stopping:
+		return nil, false
+	case <-s.done:
+		return nil, false
+	}
+	if sl.held = s.acquire(ctx); !sl.held {
+		<-slots
+		return nil, false
+	}
+	return func() { <-slots }, true
+}
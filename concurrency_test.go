@@ -0,0 +1,95 @@
+package microservice
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrencyDefaults(t *testing.T) {
+	assert.Equal(t, Concurrency{MaxInFlight: 8, Prefetch: 8}, Concurrency{MaxInFlight: 8}.normalized())
+	assert.Equal(t, DefaultConcurrency, Concurrency{}.normalized())
+}
+
+func TestCommandLimit(t *testing.T) {
+	srv := NewService(testServiceName)
+	srv.SetConcurrency(Concurrency{MaxInFlight: 4, CommandLimits: map[string]int{"slow": 1}})
+	srv.initSlots()
+	srv.init()
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		assert.True(t, srv.acquire(context.Background()))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sl := &slot{held: true}
+			defer func() {
+				if sl.held {
+					srv.release()
+				}
+			}()
+			release, ok := srv.acquireCmd(context.Background(), "slow", sl)
+			assert.True(t, ok)
+			assert.True(t, sl.held)
+			defer release()
+			n := atomic.AddInt32(&running, 1)
+			if n > atomic.LoadInt32(&peak) {
+				atomic.StoreInt32(&peak, n)
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+
+	// ожидающие запросы команды не занимают общих обработчиков
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.True(t, srv.acquire(ctx))
+	srv.release()
+
+	wg.Wait()
+	assert.Equal(t, int32(1), peak)
+
+	// прерванное ожидание не занимает общий обработчик повторно
+	assert.True(t, srv.acquire(context.Background()))
+	release, ok := srv.acquireCmd(context.Background(), "slow", &slot{held: true})
+	assert.True(t, ok)
+	for i := 0; i < 3; i++ {
+		assert.True(t, srv.acquire(context.Background()))
+	}
+	waiting := &slot{held: true}
+	_, ok = srv.acquireCmd(ctx, "slow", waiting)
+	assert.False(t, ok)
+	assert.False(t, waiting.held)
+	assert.Len(t, srv.slots, 3)
+	release()
+	for i := 0; i < 3; i++ {
+		srv.release()
+	}
+	assert.Empty(t, srv.slots)
+
+	close(srv.stopping)
+	assert.False(t, srv.acquire(context.Background()))
+}
+
+func TestRunCmdNotConnected(t *testing.T) {
+	srv := NewService(testServiceName)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		srv.RunCmd("ping", &amqp.Delivery{})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("RunCmd is blocked before connection")
+	}
+	assert.Empty(t, srv.active)
+}
@@ -0,0 +1,248 @@
+// Модуль загрузки параметров компонентов пакета из файла и переменных окружения.
+//
+// Параметры формируются в следующем порядке (каждый следующий источник имеет приоритет
+// над предыдущим):
+//
+// - умолчательные значения (см. DefaultConfig)
+//
+// - файл в формате YAML, JSON или TOML (формат определяется по расширению)
+//
+// - переменные окружения с префиксом DS_ (см. константы Env*)
+//
+// Длительности в файле и переменных окружения задаются строками вида "1m30s".
+
+package microservice
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/streadway/amqp"
+	"gopkg.in/yaml.v3"
+)
+
+// Переменные окружения, переопределяющие параметры.
+const (
+	EnvConfigFile              = "DS_CONFIG_FILE"
+	EnvAMQPURL                 = "DS_AMQP_URL"
+	EnvQueueDurable            = "DS_SERVICE_QUEUE_DURABLE"
+	EnvQueueType               = "DS_SERVICE_QUEUE_TYPE"
+	EnvQueueTTL                = "DS_SERVICE_QUEUE_TTL"
+	EnvQueueMaxLength          = "DS_SERVICE_QUEUE_MAX_LENGTH"
+	EnvDeadLetter              = "DS_SERVICE_DEAD_LETTER_EXCHANGE"
+	EnvConsumerTag             = "DS_SERVICE_CONSUMER_TAG"
+	EnvPrefetch                = "DS_SERVICE_PREFETCH"
+	EnvMaxInFlight             = "DS_SERVICE_MAX_IN_FLIGHT"
+	EnvPanicPolicy             = "DS_SERVICE_PANIC_POLICY"
+	EnvHealthAddr              = "DS_SERVICE_HEALTH_ADDR"
+	EnvServiceConfirmTimeout   = "DS_SERVICE_CONFIRM_TIMEOUT"
+	EnvClientTimeout           = "DS_CLIENT_TIMEOUT"
+	EnvClientConfirmTimeout    = "DS_CLIENT_CONFIRM_TIMEOUT"
+	EnvPublisherExchange       = "DS_PUBLISHER_EXCHANGE"
+	EnvPublisherConfirmTimeout = "DS_PUBLISHER_CONFIRM_TIMEOUT"
+	EnvPollerInterval          = "DS_WEBPOLLER_INTERVAL"
+	EnvMetricsAddr             = "DS_METRICS_ADDR"
+)
+
+// ClientConfig описывает параметры RPC-клиента.
+type ClientConfig struct {
+	URL            string        `json:"url" yaml:"url" toml:"url"`                                     // адрес брокера
+	Timeout        time.Duration `json:"timeout" yaml:"timeout" toml:"timeout"`                         // срок ожидания ответа по умолчанию
+	ConfirmTimeout time.Duration `json:"confirm_timeout" yaml:"confirm_timeout" toml:"confirm_timeout"` // срок ожидания подтверждения запроса (0 - без подтверждений)
+	Transport      Transport     `json:"-" yaml:"-" toml:"-"`                                           // транспорт (по умолчанию DefaultTransport)
+}
+
+// PublisherConfig описывает параметры издателя (см. NewPublisherFromConfig).
+type PublisherConfig struct {
+	URL            string        `json:"url" yaml:"url" toml:"url"`
+	Exchange       string        `json:"exchange" yaml:"exchange" toml:"exchange"`
+	ConfirmTimeout time.Duration `json:"confirm_timeout" yaml:"confirm_timeout" toml:"confirm_timeout"` // см. Publisher.EnableConfirms
+	Transport      Transport     `json:"-" yaml:"-" toml:"-"`                                           // транспорт (по умолчанию DefaultTransport)
+}
+
+// WebPollerConfig описывает параметры опроса http-ресурсов (см. NewWebPollerFromConfig).
+type WebPollerConfig struct {
+	Interval time.Duration `json:"interval" yaml:"interval" toml:"interval"`
+}
+
+// MetricsConfig описывает параметры http-сервера метрик (см. ServeMetricsConfig).
+// Пустой адрес означает, что сервер метрик не запускается.
+type MetricsConfig struct {
+	Addr string `json:"addr" yaml:"addr" toml:"addr"`
+}
+
+// Config объединяет параметры компонентов пакета.
+// Адрес брокера AMQPURL используется компонентами, для которых адрес не задан явно.
+type Config struct {
+	AMQPURL   string          `json:"amqp_url" yaml:"amqp_url" toml:"amqp_url"`
+	Service   ServiceConfig   `json:"service" yaml:"service" toml:"service"`
+	Client    ClientConfig    `json:"client" yaml:"client" toml:"client"`
+	Publisher PublisherConfig `json:"publisher" yaml:"publisher" toml:"publisher"`
+	WebPoller WebPollerConfig `json:"webpoller" yaml:"webpoller" toml:"webpoller"`
+	Metrics   MetricsConfig   `json:"metrics" yaml:"metrics" toml:"metrics"`
+}
+
+// DefaultConfig возвращает умолчательные параметры.
+func DefaultConfig() *Config {
+	return &Config{
+		AMQPURL:   DefaultRabbitMQConnStr,
+		Service:   DefaultServiceConfig(),
+		WebPoller: WebPollerConfig{Interval: time.Second},
+	}
+}
+
+// LoadConfig формирует параметры из умолчательных значений, файла `path` и переменных
+// окружения. Если путь не задан, используется файл из переменной DS_CONFIG_FILE, а при
+// ее отсутствии файл не загружается.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+	if path == "" {
+		path = os.Getenv(EnvConfigFile)
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return nil, err
+	}
+	cfg.fillURLs()
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Validate проверяет корректность параметров.
+func (cfg *Config) Validate() error {
+	for _, url := range []string{cfg.AMQPURL, cfg.Client.URL, cfg.Publisher.URL} {
+		if url == "" {
+			continue
+		}
+		if _, err := amqp.ParseURI(url); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidConfig, err)
+		}
+	}
+	if cfg.Client.Timeout < 0 {
+		return fmt.Errorf("%w: negative client timeout", ErrInvalidConfig)
+	}
+	if cfg.Client.ConfirmTimeout < 0 || cfg.Publisher.ConfirmTimeout < 0 {
+		return fmt.Errorf("%w: negative confirm timeout", ErrInvalidConfig)
+	}
+	if cfg.WebPoller.Interval <= 0 {
+		return fmt.Errorf("%w: web poller interval must be positive", ErrInvalidConfig)
+	}
+	return cfg.Service.Validate()
+}
+
+// loadFile загружает параметры из файла в формате, определяемом расширением.
+// JSON разбирается как подмножество YAML, что позволяет задавать длительности строками.
+func (cfg *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml", ".json":
+		err = yaml.Unmarshal(data, cfg)
+	case ".toml":
+		err = toml.Unmarshal(data, cfg)
+	default:
+		return fmt.Errorf("%w: unsupported config file format '%s'", ErrInvalidConfig, ext)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, path, err)
+	}
+	return nil
+}
+
+// loadEnv переопределяет параметры значениями переменных окружения.
+func (cfg *Config) loadEnv() error {
+	q := &cfg.Service.Queue
+	setStr(EnvAMQPURL, &cfg.AMQPURL)
+	setStr(EnvQueueType, &q.Type)
+	setStr(EnvDeadLetter, &q.DeadLetterExchange)
+	setStr(EnvConsumerTag, &cfg.Service.ConsumerTag)
+	setStr(EnvPanicPolicy, &cfg.Service.PanicPolicy)
+	setStr(EnvHealthAddr, &cfg.Service.HealthAddr)
+	setStr(EnvPublisherExchange, &cfg.Publisher.Exchange)
+	setStr(EnvMetricsAddr, &cfg.Metrics.Addr)
+	for _, err := range []error{
+		setBool(EnvQueueDurable, &q.Durable),
+		setDuration(EnvQueueTTL, &q.MessageTTL),
+		setInt(EnvQueueMaxLength, &q.MaxLength),
+		setInt(EnvPrefetch, &cfg.Service.Concurrency.Prefetch),
+		setInt(EnvMaxInFlight, &cfg.Service.Concurrency.MaxInFlight),
+		setDuration(EnvServiceConfirmTimeout, &cfg.Service.ConfirmTimeout),
+		setDuration(EnvClientTimeout, &cfg.Client.Timeout),
+		setDuration(EnvClientConfirmTimeout, &cfg.Client.ConfirmTimeout),
+		setDuration(EnvPublisherConfirmTimeout, &cfg.Publisher.ConfirmTimeout),
+		setDuration(EnvPollerInterval, &cfg.WebPoller.Interval),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fillURLs задает общий адрес брокера компонентам, для которых адрес не указан.
+func (cfg *Config) fillURLs() {
+	if cfg.Client.URL == "" {
+		cfg.Client.URL = cfg.AMQPURL
+	}
+	if cfg.Publisher.URL == "" {
+		cfg.Publisher.URL = cfg.AMQPURL
+	}
+}
+
+func setStr(name string, v *string) {
+	if s, ok := os.LookupEnv(name); ok {
+		*v = s
+	}
+}
+
+func setBool(name string, v *bool) error {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, name, err)
+	}
+	*v = b
+	return nil
+}
+
+func setInt(name string, v *int) error {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, name, err)
+	}
+	*v = n
+	return nil
+}
+
+func setDuration(name string, v *time.Duration) error {
+	s, ok := os.LookupEnv(name)
+	if !ok {
+		return nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrInvalidConfig, name, err)
+	}
+	*v = d
+	return nil
+}
@@ -0,0 +1,164 @@
+// Модуль подтверждения публикации сообщений брокером (publisher confirms).
+//
+// В режиме подтверждений канал брокера переводится в confirm-режим, каждой публикации
+// присваивается порядковый номер, а публикующий дожидается подтверждения (ack) или
+// отказа (nack) брокера в течение заданного срока. Публикация без подтверждения
+// завершается ошибкой ErrNotConfirmed.
+//
+// Режим включается отдельно для каждого компонента: ServiceConfig.ConfirmTimeout (ответы
+// микросервиса), ClientConfig.ConfirmTimeout (запросы RPC-клиента) и
+// Publisher.EnableConfirms (события издателя). Для высокой пропускной способности издатель
+// поддерживает асинхронные подтверждения: Publisher.EmitAsync и Publisher.WaitConfirms.
+
+package microservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// ErrNotConfirmed возвращается, если брокер отказал в публикации сообщения или не
+// подтвердил ее в срок.
+var ErrNotConfirmed = errors.New("publish not confirmed by broker")
+
+// confirmTracker сопоставляет подтверждения брокера публикациям канала.
+type confirmTracker struct {
+	mu      sync.Mutex
+	seq     uint64
+	pending map[uint64]chan error
+}
+
+// startConfirms переводит канал в confirm-режим и запускает прием подтверждений.
+func startConfirms(ch Channel) (*confirmTracker, error) {
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 64))
+	if err := ch.Confirm(false); err != nil {
+		return nil, err
+	}
+	t := &confirmTracker{pending: map[uint64]chan error{}}
+	go t.run(confirms)
+	return t, nil
+}
+
+// run передает подтверждения ожидающим публикациям. При закрытии канала ожидание
+// оставшихся публикаций завершается ошибкой.
+func (t *confirmTracker) run(confirms chan amqp.Confirmation) {
+	for c := range confirms {
+		t.mu.Lock()
+		done, ok := t.pending[c.DeliveryTag]
+		delete(t.pending, c.DeliveryTag)
+		t.mu.Unlock()
+		if !ok {
+			continue
+		}
+		if c.Ack {
+			done <- nil
+		} else {
+			done <- fmt.Errorf("%w: nack", ErrNotConfirmed)
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for tag, done := range t.pending {
+		done <- fmt.Errorf("%w: channel closed", ErrNotConfirmed)
+		delete(t.pending, tag)
+	}
+}
+
+// publish выполняет публикацию `f` и регистрирует ожидание ее подтверждения.
+// Номера публикаций присваиваются в порядке вызовов `f`.
+func (t *confirmTracker) publish(f func() error) (chan error, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := f(); err != nil {
+		return nil, err
+	}
+	t.seq++
+	done := make(chan error, 1)
+	t.pending[t.seq] = done
+	return done, nil
+}
+
+// confirmation описывает ожидание подтверждения публикации.
+// Нулевое значение соответствует публикации без подтверждения.
+type confirmation struct {
+	done    chan error
+	timeout time.Duration
+}
+
+// wait дожидается подтверждения публикации в течение срока ожидания, но не дольше срока
+// контекста `ctx`.
+func (c confirmation) wait(ctx context.Context) error {
+	if c.done == nil {
+		return nil
+	}
+	timer := time.NewTimer(c.timeout)
+	defer timer.Stop()
+	select {
+	case err := <-c.done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w: no confirmation in %s", ErrNotConfirmed, c.timeout)
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrNotConfirmed, ctx.Err())
+	}
+}
+
+// setConfirmTimeout включает режим подтверждения публикаций со сроком ожидания `timeout`
+// (0 - режим выключен). Вызывается до подключения.
+func (m *connManager) setConfirmTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.confirmTimeout = timeout
+}
+
+// publish публикует сообщение на текущем канале брокера и возвращает ожидание его
+// подтверждения, если режим подтверждений включен.
+func (m *connManager) publish(exchange, key string, mandatory bool, msg amqp.Publishing) (confirmation, error) {
+	return m.publishFunc(exchange, key, mandatory, func() amqp.Publishing { return msg })
+}
+
+// publishFunc публикует сообщение, сформированное функцией `build` под блокировкой
+// подключения, поэтому сообщение может ссылаться на топологию текущего канала (например,
+// на имя очереди ответов), не изменяемую до завершения публикации.
+func (m *connManager) publishFunc(
+	exchange, key string, mandatory bool, build func() amqp.Publishing) (confirmation, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.ch == nil {
+		if m.state == StateClosed {
+			return confirmation{}, ErrClosed
+		}
+		return confirmation{}, ErrNotConnected
+	}
+	ch, msg := m.ch, build()
+	f := func() error {
+		return ch.Publish(exchange, key, mandatory, false, msg)
+	}
+	if m.confirms == nil {
+		return confirmation{}, f()
+	}
+	done, err := m.confirms.publish(f)
+	return confirmation{done: done, timeout: m.confirmTimeout}, err
+}
+
+// publishConfirmed публикует сообщение и дожидается его подтверждения.
+func (m *connManager) publishConfirmed(
+	ctx context.Context, exchange, key string, mandatory bool, msg amqp.Publishing) error {
+	return m.publishConfirmedFunc(ctx, exchange, key, mandatory, func() amqp.Publishing { return msg })
+}
+
+// publishConfirmedFunc публикует сообщение, сформированное функцией `build` (см. publishFunc),
+// и дожидается его подтверждения.
+func (m *connManager) publishConfirmedFunc(
+	ctx context.Context, exchange, key string, mandatory bool, build func() amqp.Publishing) error {
+	c, err := m.publishFunc(exchange, key, mandatory, build)
+	if err != nil {
+		return err
+	}
+	return c.wait(ctx)
+}
@@ -0,0 +1,113 @@
+package microservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublisherConfirms(t *testing.T) {
+	broker := NewMemoryBroker()
+	sub := NewSubscriber("events")
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	defer sub.Close()
+
+	pub := NewPublisher("events")
+	pub.EnableConfirms(time.Second)
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+
+	require.NoError(t, pub.Emit("text/plain", []byte("confirmed")))
+	event, err := sub.ReceiveOnceE()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("confirmed"), event.Body)
+
+	broker.NackPublishes(true)
+	err = pub.Emit("text/plain", []byte("nacked"))
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+	assert.ErrorIs(t, err, ErrPublish)
+
+	broker.NackPublishes(false)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, pub.EmitAsync("text/plain", []byte("async")))
+	}
+	assert.NoError(t, pub.WaitConfirms(ctx))
+
+	require.NoError(t, pub.EmitAsync("text/plain", []byte("async")))
+	broker.NackPublishes(true)
+	require.NoError(t, pub.EmitAsync("text/plain", []byte("async")))
+	err = pub.WaitConfirms(ctx)
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+	assert.Contains(t, err.Error(), "1 of 2 messages")
+	assert.NoError(t, pub.WaitConfirms(ctx))
+
+	c := confirmation{done: make(chan error), timeout: time.Millisecond}
+	assert.ErrorIs(t, c.wait(ctx), ErrNotConfirmed)
+}
+
+func TestRPCConfirms(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := NewService(testServiceName, WithPublisherConfirms(time.Second))
+	srv.SetTransport(broker)
+	require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+	go srv.Run(ctx)
+	defer srv.Cleanup()
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{
+		URL: memoryConnStr, Timeout: time.Second, ConfirmTimeout: time.Second, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+
+	ping, err := Call[interface{}, PingResult](ctx, cl, testServiceName, "ping", nil)
+	require.NoError(t, err)
+	assert.Equal(t, testServiceName, ping.Service)
+
+	plain, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Transport: broker})
+	require.NoError(t, err)
+	defer plain.Close()
+	broker.NackPublishes(true)
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer timeoutCancel()
+	_, err = Call[interface{}, PingResult](timeoutCtx, plain, testServiceName, "ping", nil)
+	assert.ErrorIs(t, err, ErrTimeout)
+	// обработанный запрос не выполняется повторно при неподтвержденном ответе
+	assert.Eventually(t, func() bool {
+		return broker.Stats(testServiceName).Acked == 2
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, broker.Stats(testServiceName).Requeued)
+	assert.Equal(t, 2, broker.Stats(testServiceName).Delivered)
+
+	dlx := NewService("dlx_service", WithPublisherConfirms(time.Second), WithDeadLetter("dlx", ""))
+	dlx.SetTransport(broker)
+	require.NoError(t, dlx.ConnectToMessageBrokerE(memoryConnStr))
+	go dlx.Run(ctx)
+	defer dlx.Cleanup()
+	dlxCtx, dlxCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer dlxCancel()
+	_, err = Call[interface{}, PingResult](dlxCtx, plain, "dlx_service", "ping", nil)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Eventually(t, func() bool {
+		return broker.Stats("dlx_service").Rejected == 1
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, 0, broker.Stats("dlx_service").Requeued)
+
+	correlationID, _, _ := CreateCmdRequest("")
+	err = cl.RequestE(testServiceName, correlationID, []byte("{}"))
+	assert.ErrorIs(t, err, ErrNotConfirmed)
+	assert.Equal(t, 0, broker.QueueLength(testServiceName))
+
+	broker.NackPublishes(false)
+	_, err = Call[interface{}, PingResult](ctx, plain, testServiceName, "ping", nil)
+	assert.NoError(t, err)
+
+	_, err = NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, ConfirmTimeout: -1, Transport: broker})
+	assert.ErrorIs(t, err, ErrInvalidConfig)
+}
@@ -0,0 +1,334 @@
+// Модуль управления подключением к брокеру сообщений.
+//
+// При разрыве соединения подключение восстанавливается с нарастающей задержкой, после чего
+// заново объявляется топология компонента: очереди, точки обмена, привязки, QoS и потребители.
+
+package microservice
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Ошибки состояния подключения.
+var (
+	ErrNotConnected = errors.New("not connected to message broker")
+	ErrClosed       = errors.New("connection is closed")
+)
+
+// ConnState описывает состояние подключения к брокеру сообщений.
+type ConnState int
+
+// Состояния подключения.
+const (
+	StateDisconnected ConnState = iota
+	StateConnected
+	StateReconnecting
+	StateClosed
+)
+
+func (st ConnState) String() string {
+	switch st {
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateClosed:
+		return "closed"
+	default:
+		return "disconnected"
+	}
+}
+
+// ConnEvent описывает изменение состояния подключения.
+type ConnEvent struct {
+	State ConnState
+	Err   error // причина разрыва или ошибка очередной попытки подключения
+}
+
+// Backoff описывает политику задержек между попытками восстановления подключения.
+type Backoff struct {
+	Initial    time.Duration
+	Max        time.Duration
+	Multiplier float64
+}
+
+// DefaultBackoff - умолчательная политика восстановления подключения.
+var DefaultBackoff = Backoff{Initial: 500 * time.Millisecond, Max: 30 * time.Second, Multiplier: 2}
+
+// Delay возвращает задержку перед попыткой подключения с номером `attempt` (начиная с 0).
+// Множитель меньше 1 заменяется на DefaultBackoff.Multiplier, чтобы задержка не убывала.
+func (b Backoff) Delay(attempt int) time.Duration {
+	if b.Initial <= 0 {
+		b = DefaultBackoff
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoff.Multiplier
+	}
+	d := float64(b.Initial)
+	for i := 0; i < attempt && (b.Max <= 0 || d < float64(b.Max)); i++ {
+		d *= b.Multiplier
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		return b.Max
+	}
+	return time.Duration(d)
+}
+
+// topologyFunc объявляет объекты брокера на заданном канале.
+type topologyFunc func(ch Channel) error
+
+// connManager хранит подключение к брокеру и топологию компонента для ее восстановления.
+// Встраивается в Service, RPCClient, Publisher и Subscriber.
+type connManager struct {
+	initOnce  sync.Once
+	closeOnce sync.Once
+	done      chan struct{}
+
+	mu        sync.RWMutex
+	url       string
+	transport Transport
+	backoff   Backoff
+	conn      Connection
+	ch        Channel
+	state     ConnState
+	topology  []topologyFunc
+	listeners []chan ConnEvent
+
+	confirmTimeout time.Duration   // срок ожидания подтверждения публикации (0 - без подтверждений)
+	confirms       *confirmTracker // подтверждения публикаций текущего канала
+}
+
+func (m *connManager) init() {
+	m.initOnce.Do(func() {
+		m.done = make(chan struct{})
+	})
+}
+
+// State возвращает текущее состояние подключения.
+func (m *connManager) State() ConnState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// SetBackoff задает политику восстановления подключения.
+func (m *connManager) SetBackoff(b Backoff) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.backoff = b
+}
+
+// SetTransport задает транспорт подключения к брокеру (по умолчанию DefaultTransport).
+// Должен вызываться до подключения.
+func (m *connManager) SetTransport(t Transport) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.transport = t
+}
+
+// NotifyState регистрирует канал для получения событий изменения состояния подключения.
+// События, которые не удалось передать в заполненный канал, отбрасываются, поэтому
+// канал следует делать буферизованным.
+func (m *connManager) NotifyState(c chan ConnEvent) chan ConnEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listeners = append(m.listeners, c)
+	return c
+}
+
+// connect выполняет подключение к брокеру по адресу `url` и объявляет топологию `steps`.
+// В случае успеха запускается наблюдение за подключением.
+func (m *connManager) connect(url string, steps ...topologyFunc) error {
+	m.init()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state == StateClosed {
+		return ErrClosed
+	}
+	m.url = url
+	if m.transport == nil {
+		m.transport = DefaultTransport
+	}
+	m.topology = append(m.topology, steps...)
+	connClosed, chClosed, err := m.open()
+	if err != nil {
+		m.topology = nil
+		return err
+	}
+	go m.watch(connClosed, chClosed)
+	return nil
+}
+
+// open устанавливает подключение и объявляет топологию. Вызывается под блокировкой.
+func (m *connManager) open() (connClosed, chClosed chan *amqp.Error, err error) {
+	conn, err := m.transport.Dial(m.url)
+	if err != nil {
+		return nil, nil, brokerError(ErrConnect, "", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, nil, brokerError(ErrChannel, "", err)
+	}
+	connClosed = conn.NotifyClose(make(chan *amqp.Error, 1))
+	chClosed = ch.NotifyClose(make(chan *amqp.Error, 1))
+	var confirms *confirmTracker
+	if m.confirmTimeout > 0 {
+		if confirms, err = startConfirms(ch); err != nil {
+			conn.Close()
+			return nil, nil, brokerError(ErrChannel, "", err)
+		}
+	}
+	for _, step := range m.topology {
+		if err = step(ch); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	m.conn, m.ch, m.confirms = conn, ch, confirms
+	m.setState(StateConnected, nil)
+	return connClosed, chClosed, nil
+}
+
+// watch дожидается разрыва подключения и восстанавливает его до закрытия менеджера.
+func (m *connManager) watch(connClosed, chClosed chan *amqp.Error) {
+	for {
+		var reason *amqp.Error
+		select {
+		case <-m.done:
+			return
+		case reason = <-connClosed:
+		case reason = <-chClosed:
+		}
+		if m.isClosed() {
+			return
+		}
+		log.WithField("context", "Broker connection").Warn(reason)
+
+		m.mu.Lock()
+		m.conn.Close()
+		m.conn, m.ch, m.confirms = nil, nil, nil
+		if reason != nil {
+			m.setState(StateReconnecting, reason)
+		} else {
+			m.setState(StateReconnecting, ErrNotConnected)
+		}
+		m.mu.Unlock()
+
+		var err error
+		for attempt := 0; ; attempt++ {
+			select {
+			case <-m.done:
+				return
+			case <-time.After(m.delay(attempt)):
+			}
+			m.mu.Lock()
+			if m.state == StateClosed {
+				m.mu.Unlock()
+				return
+			}
+			connClosed, chClosed, err = m.open()
+			if err != nil {
+				m.setState(StateReconnecting, err)
+			}
+			m.mu.Unlock()
+			if err == nil {
+				break
+			}
+			log.WithField("context", "Broker reconnection").Error(err)
+		}
+	}
+}
+
+func (m *connManager) delay(attempt int) time.Duration {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.backoff.Delay(attempt)
+}
+
+// declare объявляет дополнительный элемент топологии на текущем канале и запоминает его
+// для восстановления после переподключения.
+func (m *connManager) declare(step topologyFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ch == nil {
+		return ErrNotConnected
+	}
+	if err := step(m.ch); err != nil {
+		return err
+	}
+	m.topology = append(m.topology, step)
+	return nil
+}
+
+// withChannel вызывает `f` для текущего канала брокера.
+// Топология на время вызова не изменяется.
+func (m *connManager) withChannel(f func(ch Channel) error) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.ch == nil {
+		if m.state == StateClosed {
+			return ErrClosed
+		}
+		return ErrNotConnected
+	}
+	return f(m.ch)
+}
+
+// forward пересылает сообщения потребителя `msgs` в канал `out`, который не зависит от
+// переподключений.
+func (m *connManager) forward(msgs <-chan amqp.Delivery, out chan<- amqp.Delivery) {
+	for d := range msgs {
+		select {
+		case out <- d:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+// close закрывает подключение и прекращает его восстановление.
+func (m *connManager) close() error {
+	m.init()
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.done)
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.ch != nil {
+			m.ch.Close()
+		}
+		if m.conn != nil {
+			err = m.conn.Close()
+		}
+		m.conn, m.ch, m.confirms = nil, nil, nil
+		m.setState(StateClosed, nil)
+	})
+	return err
+}
+
+func (m *connManager) isClosed() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// setState изменяет состояние и оповещает подписчиков. Вызывается под блокировкой.
+func (m *connManager) setState(state ConnState, err error) {
+	m.state = state
+	ev := ConnEvent{State: state, Err: err}
+	for _, c := range m.listeners {
+		select {
+		case c <- ev:
+		default:
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package microservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: 100 * time.Millisecond, Max: time.Second, Multiplier: 2}
+	assert.Equal(t, 100*time.Millisecond, b.Delay(0))
+	assert.Equal(t, 400*time.Millisecond, b.Delay(2))
+	assert.Equal(t, time.Second, b.Delay(10))
+	assert.Equal(t, DefaultBackoff.Initial, Backoff{}.Delay(0))
+
+	b = Backoff{Initial: 100 * time.Millisecond, Max: time.Second}
+	assert.Equal(t, 100*time.Millisecond, b.Delay(0))
+	assert.Equal(t, 400*time.Millisecond, b.Delay(2))
+	b.Multiplier = 0.5
+	assert.Equal(t, 200*time.Millisecond, b.Delay(1))
+}
+
+func TestConnStateEvents(t *testing.T) {
+	var m connManager
+	events := m.NotifyState(make(chan ConnEvent, 1))
+	assert.Equal(t, StateDisconnected, m.State())
+	m.close()
+	assert.Equal(t, StateClosed, (<-events).State)
+	assert.ErrorIs(t, m.withChannel(nil), ErrClosed)
+}
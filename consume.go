@@ -0,0 +1,180 @@
+// Модуль обработки событий подписчиком с ручным квитированием.
+//
+// Subscriber.Consume передает события обработчику и подтверждает событие только после
+// его успешной обработки, поэтому событие, обработка которого была прервана сбоем
+// подписчика, будет доставлено повторно. Событие, обработчик которого вернул ошибку,
+// обрабатывается в соответствии с политикой RetryPolicy (см. WithRetryPolicy):
+//
+// - по умолчанию событие отклоняется и перенаправляется в точку обмена
+// QueueConfig.DeadLetterExchange очереди подписки (при ее отсутствии - удаляется)
+//
+// - при RetryPolicy.Requeue событие возвращается в очередь для немедленной повторной
+// обработки
+//
+// - при RetryPolicy.MaxRetries > 0 событие помещается в очередь повторов
+// `<имя подписки>.retry`, откуда по истечении RetryPolicy.Delay возвращается в очередь
+// подписки. Число выполненных повторов определяется по заголовку x-death, и после
+// исчерпания повторов событие отклоняется.
+
+package microservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// DefaultSubscriberPrefetch - умолчательное число событий, передаваемых подписчику до их
+// подтверждения в Consume (см. WithSubscriberPrefetch).
+const DefaultSubscriberPrefetch = 16
+
+// ErrConsumeMode возвращается при попытке получать события подписчика одновременно
+// методами Consume и Receive (ReceiveOnce).
+var ErrConsumeMode = errors.New("subscriber is already consuming in another ack mode")
+
+// RetryPolicy описывает обработку событий, обработчик которых вернул ошибку.
+type RetryPolicy struct {
+	// число повторных попыток обработки (требует именованной подписки, см. WithSubscription)
+	MaxRetries int `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
+	// задержка повторной попытки обработки
+	Delay time.Duration `json:"delay" yaml:"delay" toml:"delay"`
+	// немедленно возвращать событие в очередь без ограничения числа попыток
+	// (MaxRetries и Delay не используются)
+	Requeue bool `json:"requeue" yaml:"requeue" toml:"requeue"`
+}
+
+// Validate проверяет корректность политики повторов.
+func (p RetryPolicy) Validate() error {
+	if p.MaxRetries < 0 || p.Delay < 0 {
+		return fmt.Errorf("%w: negative retry policy value", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// WithRetryPolicy задает политику обработки событий, обработчик которых вернул ошибку
+// (см. Subscriber.Consume). Для издателя параметр не используется.
+func WithRetryPolicy(p RetryPolicy) ExchangeOption {
+	return func(cfg *exchangeConfig) { cfg.retry = p }
+}
+
+// Event описывает событие, полученное подписчиком.
+type Event struct {
+	amqp.Delivery
+	Retries int // число выполненных повторных попыток обработки
+}
+
+// Context возвращает контекст, содержащий контекст трассировки события.
+func (ev Event) Context() context.Context {
+	return ContextFromDelivery(context.Background(), &ev.Delivery)
+}
+
+// retryQueue возвращает имя очереди повторов подписки или пустую строку, если повторы
+// не используются.
+func (sub *Subscriber) retryQueue() string {
+	if sub.retry.MaxRetries == 0 || sub.retry.Requeue || sub.queueName == "" {
+		return ""
+	}
+	return sub.queueName + ".retry"
+}
+
+// declareRetryQueue объявляет очередь повторов, сообщения которой по истечении задержки
+// возвращаются в очередь подписки.
+func (sub *Subscriber) declareRetryQueue(ch Channel) error {
+	name := sub.retryQueue()
+	if name == "" {
+		return nil
+	}
+	_, err := ch.QueueDeclare(
+		name,
+		sub.queueCfg.Durable,
+		false,
+		false,
+		false,
+		amqp.Table{
+			"x-message-ttl":             int64(sub.retry.Delay / time.Millisecond),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": sub.q.Name,
+		},
+	)
+	return brokerError(ErrDeclare, name, err)
+}
+
+// Consume передает события обработчику `h` до отмены контекста `ctx` или закрытия
+// подписчика. Событие подтверждается после успешной обработки, а при ошибке
+// обработчика отклоняется, возвращается в очередь или повторяется в соответствии с
+// политикой повторов (см. WithRetryPolicy).
+// Неподтвержденные события возвращаются брокером в очередь при закрытии подписчика.
+func (sub *Subscriber) Consume(ctx context.Context, h func(Event) error) error {
+	if err := sub.consume(false); err != nil {
+		return err
+	}
+	for {
+		select {
+		case d := <-sub.deliveries:
+			sub.handle(d, h)
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-sub.done:
+			return ErrClosed
+		}
+	}
+}
+
+// handle обрабатывает событие `d` и квитирует его в соответствии с результатом.
+func (sub *Subscriber) handle(d amqp.Delivery, h func(Event) error) {
+	retryQueue := sub.retryQueue()
+	ev := Event{Delivery: d, Retries: deathCount(d.Headers, retryQueue)}
+	err := h(ev)
+	entry := log.WithField("context", "Subscriber").WithField("exchange", sub.exchName)
+	switch {
+	case err == nil:
+		err = d.Ack(false)
+	case sub.retry.Requeue:
+		entry.WithError(err).Warn("event requeued")
+		err = d.Nack(false, true)
+	case retryQueue != "" && ev.Retries < sub.retry.MaxRetries:
+		entry.WithError(err).Warnf("event retry %d of %d", ev.Retries+1, sub.retry.MaxRetries)
+		err = sub.retryLater(d, retryQueue)
+	default:
+		entry.WithError(err).Error("event rejected")
+		err = d.Nack(false, false)
+	}
+	if err != nil {
+		entry.Error(err)
+	}
+}
+
+// retryLater помещает копию события в очередь повторов и подтверждает исходное событие.
+// Если копию опубликовать не удалось, событие возвращается в очередь.
+func (sub *Subscriber) retryLater(d amqp.Delivery, retryQueue string) error {
+	c, err := sub.publish("", retryQueue, false, publishingOf(d))
+	if err == nil {
+		err = c.wait(context.Background())
+	}
+	if err != nil {
+		log.WithField("context", "Subscriber").Error(brokerError(ErrPublish, retryQueue, err))
+		return d.Nack(false, true)
+	}
+	return d.Ack(false)
+}
+
+// deathCount возвращает число перенаправлений сообщения из очереди `queue` по истечении
+// времени жизни согласно заголовку x-death.
+func deathCount(headers amqp.Table, queue string) int {
+	if queue == "" {
+		return 0
+	}
+	deaths, _ := headers["x-death"].([]interface{})
+	for _, d := range deaths {
+		t, ok := d.(amqp.Table)
+		if ok && t["queue"] == queue && t["reason"] == "expired" {
+			n, _ := tableInt(t, "count")
+			return int(n)
+		}
+	}
+	return 0
+}
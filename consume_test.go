@@ -0,0 +1,150 @@
+package microservice
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errHandler = errors.New("handler failed")
+
+// consumeEvents запускает обработку событий подписчиком и возвращает функцию ее остановки.
+func consumeEvents(t *testing.T, sub *Subscriber, h func(Event) error) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- sub.Consume(ctx, h) }()
+	return func() {
+		cancel()
+		assert.ErrorIs(t, <-done, context.Canceled)
+	}
+}
+
+func TestSubscriberConsume(t *testing.T) {
+	broker := NewMemoryBroker()
+	conn, err := broker.Dial(memoryConnStr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	require.NoError(t, ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, false, false, false, false, nil))
+	_, err = ch.QueueDeclare("dead", false, false, false, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, ch.QueueBind("dead", "", "dlx", false, nil))
+
+	sub := NewSubscriber("tracks",
+		WithSubscription("indexer", QueueConfig{DeadLetterExchange: "dlx"}),
+		WithRetryPolicy(RetryPolicy{MaxRetries: 2, Delay: time.Millisecond}))
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	defer sub.Close()
+
+	var mu sync.Mutex
+	attempts := map[string][]int{}
+	stop := consumeEvents(t, sub, func(ev Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[string(ev.Body)] = append(attempts[string(ev.Body)], ev.Retries)
+		if string(ev.Body) == "ok" {
+			return nil
+		}
+		return errHandler
+	})
+
+	pub := NewPublisher("tracks")
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+	require.NoError(t, pub.Emit("text/plain", []byte("ok")))
+	require.NoError(t, pub.Emit("text/plain", []byte("broken")))
+
+	assert.Eventually(t, func() bool { return broker.QueueLength("dead") == 1 }, time.Second, time.Millisecond)
+	stop()
+	mu.Lock()
+	assert.Equal(t, map[string][]int{"ok": {0}, "broken": {0, 1, 2}}, attempts)
+	mu.Unlock()
+	stats := broker.Stats("indexer")
+	assert.Equal(t, 3, stats.Acked)
+	assert.Equal(t, 1, stats.Rejected)
+	assert.Equal(t, 0, broker.QueueLength("indexer.retry"))
+
+	_, err = sub.ReceiveOnceE()
+	assert.ErrorIs(t, err, ErrConsumeMode)
+
+	bad := NewSubscriber("tracks", WithRetryPolicy(RetryPolicy{MaxRetries: 1}))
+	bad.SetTransport(broker)
+	assert.ErrorIs(t, bad.ConnectE(memoryConnStr), ErrInvalidConfig)
+}
+
+func TestSubscriberConsumeRequeue(t *testing.T) {
+	broker := NewMemoryBroker()
+	sub := NewSubscriber("tracks", WithRetryPolicy(RetryPolicy{Requeue: true}))
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	defer sub.Close()
+
+	var mu sync.Mutex
+	var redelivered []bool
+	stop := consumeEvents(t, sub, func(ev Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		redelivered = append(redelivered, ev.Redelivered)
+		if len(redelivered) == 1 {
+			return errHandler
+		}
+		return nil
+	})
+
+	pub := NewPublisher("tracks")
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+	require.NoError(t, pub.Emit("text/plain", []byte("event")))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(redelivered) == 2
+	}, time.Second, time.Millisecond)
+	stop()
+	mu.Lock()
+	assert.Equal(t, []bool{false, true}, redelivered)
+	mu.Unlock()
+}
+
+func TestSubscriberConsumePrefetch(t *testing.T) {
+	broker := NewMemoryBroker()
+	sub := NewSubscriber("tracks", WithSubscription("indexer", QueueConfig{}), WithSubscriberPrefetch(2))
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	defer sub.Close()
+
+	release := make(chan struct{})
+	stop := consumeEvents(t, sub, func(ev Event) error {
+		<-release
+		return nil
+	})
+
+	pub := NewPublisher("tracks")
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+	for i := 0; i < 5; i++ {
+		require.NoError(t, pub.Emit("text/plain", []byte("event")))
+	}
+	assert.Eventually(t, func() bool { return broker.QueueLength("indexer") == 3 },
+		time.Second, time.Millisecond)
+	close(release)
+	assert.Eventually(t, func() bool { return broker.Stats("indexer").Acked == 5 },
+		time.Second, time.Millisecond)
+	stop()
+
+	bad := NewSubscriber("tracks", WithSubscriberPrefetch(-1))
+	bad.SetTransport(broker)
+	assert.ErrorIs(t, bad.ConnectE(memoryConnStr), ErrInvalidConfig)
+}
@@ -0,0 +1,99 @@
+// Модуль формата сообщений, которыми обмениваются микросервисы и их клиенты.
+//
+// Запрос и ответ передаются в едином версионированном конверте:
+//
+//	{"v": 1, "cmd": "...", "params": {...}, "meta": {...}}
+//	{"v": 1, "result": {...}}
+//	{"v": 1, "error": {...}}
+//
+// Запросы без версии (например, {"cmd": "ping"}) обрабатываются как запросы в прежнем формате.
+
+package microservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// EnvelopeVersion - текущая версия формата конверта.
+const EnvelopeVersion = 1
+
+// ErrUnsupportedVersion возвращается для конвертов неизвестной версии.
+var ErrUnsupportedVersion = errors.New("unsupported envelope version")
+
+// Envelope описывает конверт запроса или ответа микросервиса.
+type Envelope struct {
+	Version int               `json:"v,omitempty"`
+	Cmd     string            `json:"cmd,omitempty"`
+	Params  json.RawMessage   `json:"params,omitempty"`
+	Result  json.RawMessage   `json:"result,omitempty"`
+	Error   *RPCError         `json:"error,omitempty"`
+	Meta    map[string]string `json:"meta,omitempty"`
+}
+
+// NewRequestEnvelope формирует конверт запроса команды `cmd` с параметрами `params`.
+// Пустые параметры (nil) в конверт не включаются.
+func NewRequestEnvelope(cmd string, params interface{}) (*Envelope, error) {
+	env := &Envelope{Version: EnvelopeVersion, Cmd: cmd}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, err
+		}
+		env.Params = data
+	}
+	return env, nil
+}
+
+// NewResultEnvelope формирует конверт успешного ответа с результатом `result`.
+func NewResultEnvelope(result interface{}) (*Envelope, error) {
+	env := &Envelope{Version: EnvelopeVersion}
+	if result != nil {
+		data, err := json.Marshal(result)
+		if err != nil {
+			return nil, err
+		}
+		env.Result = data
+	}
+	return env, nil
+}
+
+// NewErrorEnvelope формирует конверт ответа с ошибкой `e`, возникшей в контексте `context`.
+// Ошибка передается в структурированном виде (см. RPCError).
+func NewErrorEnvelope(e error, context string) *Envelope {
+	return &Envelope{Version: EnvelopeVersion, Error: AsRPCError(e, context)}
+}
+
+// ParseEnvelope разбирает конверт из JSON-данных и проверяет его версию.
+func ParseEnvelope(data []byte) (*Envelope, error) {
+	env := &Envelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, err
+	}
+	if env.Version > EnvelopeVersion {
+		return nil, fmt.Errorf("%w: %d", ErrUnsupportedVersion, env.Version)
+	}
+	return env, nil
+}
+
+// Err возвращает ошибку *RPCError, переданную в конверте ответа, или nil для успешного
+// ответа.
+func (env *Envelope) Err() error {
+	if env.Error == nil {
+		return nil
+	}
+	return env.Error
+}
+
+// Decode декодирует результат ответа в `v`.
+// Для ответа с ошибкой возвращается эта ошибка.
+func (env *Envelope) Decode(v interface{}) error {
+	if err := env.Err(); err != nil {
+		return err
+	}
+	if len(env.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(env.Result, v)
+}
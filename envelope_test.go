@@ -0,0 +1,54 @@
+package microservice
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	env, err := NewRequestEnvelope("sum", []int{1, 2})
+	require.NoError(t, err)
+	data, err := json.Marshal(env)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"v":1,"cmd":"sum","params":[1,2]}`, string(data))
+
+	env, err = NewResultEnvelope(3)
+	require.NoError(t, err)
+	data, _ = json.Marshal(env)
+	env, err = ParseEnvelope(data)
+	require.NoError(t, err)
+	var sum int
+	require.NoError(t, env.Decode(&sum))
+	assert.Equal(t, 3, sum)
+
+	data, _ = json.Marshal(NewErrorEnvelope(errors.New("bad \"quoted\"\n\\"), "sum"))
+	env, err = ParseEnvelope(data)
+	require.NoError(t, err)
+	err = env.Decode(&sum)
+	var rpcErr *RPCError
+	require.True(t, errors.As(err, &rpcErr))
+	assert.Equal(t, &RPCError{Code: CodeInternal, Message: "bad \"quoted\"\n\\", Context: "sum"}, rpcErr)
+
+	_, err = ParseEnvelope([]byte(`{"v":100}`))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+}
+
+func TestHandleTyped(t *testing.T) {
+	srv := NewService(testServiceName)
+	HandleTyped(srv, "sum", func(req *Request, params []int) (int, error) {
+		return params[0] + params[1], nil
+	})
+	h, ok := srv.handler("sum")
+	require.True(t, ok)
+	result, err := h(&Request{Params: json.RawMessage(`[1,2]`), Delivery: &amqp.Delivery{}})
+	require.NoError(t, err)
+	assert.Equal(t, 3, result)
+
+	_, err = h(&Request{Params: json.RawMessage(`{}`), Delivery: &amqp.Delivery{}})
+	assert.Error(t, err)
+}
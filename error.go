@@ -1,11 +1,201 @@
 package microservice
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"runtime/debug"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Категории ошибок взаимодействия с брокером сообщений.
+// Проверка категории выполняется с помощью errors.Is, а исходная ошибка драйвера AMQP
+// доступна через errors.As.
+var (
+	ErrConnect = errors.New("failed to connect to message broker")
+	ErrChannel = errors.New("failed to open a channel")
+	ErrDeclare = errors.New("failed to declare a broker object")
+	ErrBind    = errors.New("failed to bind a queue")
+	ErrQos     = errors.New("failed to set QoS")
+	ErrConsume = errors.New("failed to register a consumer")
+	ErrPublish = errors.New("failed to publish a message")
+	ErrAck     = errors.New("acknowledge error")
+)
+
+// BrokerError описывает ошибку операции с брокером сообщений.
+type BrokerError struct {
+	Kind   error  // категория ошибки (ErrConnect, ErrPublish и т.д.)
+	Object string // имя очереди, точки обмена или адрес брокера
+	Err    error  // исходная ошибка
+}
+
+// Error возвращает текстовое описание ошибки.
+func (e *BrokerError) Error() string {
+	msg := e.Kind.Error()
+	if e.Object != "" {
+		msg += " '" + e.Object + "'"
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap возвращает исходную ошибку.
+func (e *BrokerError) Unwrap() error {
+	return e.Err
+}
+
+// Is сообщает о соответствии ошибки категории `target`.
+func (e *BrokerError) Is(target error) bool {
+	return e.Kind == target
+}
+
+// brokerError оборачивает ошибку `err` операции с брокером. Для nil возвращает nil.
+func brokerError(kind error, object string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &BrokerError{Kind: kind, Object: object, Err: err}
+}
+
+// Коды ошибок обработки запросов.
+const (
+	CodeUnknownCommand   = "unknown_command"   // команда не поддерживается микросервисом
+	CodeInvalidParams    = "invalid_params"    // некорректный запрос или его параметры
+	CodeInternal         = "internal"          // внутренняя ошибка микросервиса (по умолчанию)
+	CodeUnavailable      = "unavailable"       // микросервис или его ресурс недоступен
+	CodeTimeout          = "timeout"           // истек срок ожидания ответа
+	CodePermissionDenied = "permission_denied" // недостаточно прав для выполнения команды
+)
+
+// Эталонные ошибки для сравнения с помощью errors.Is.
+// Сравнение выполняется по коду ошибки, поэтому ошибки, полученные от микросервиса,
+// соответствуют эталонным с тем же кодом.
+var (
+	ErrUnknownCommand   = NewRPCError(CodeUnknownCommand, "unknown command")
+	ErrInvalidParams    = NewRPCError(CodeInvalidParams, "invalid params")
+	ErrInternal         = NewRPCError(CodeInternal, "internal error")
+	ErrUnavailable      = NewRPCError(CodeUnavailable, "service unavailable")
+	ErrTimeout          = NewRPCError(CodeTimeout, "request timeout")
+	ErrPermissionDenied = NewRPCError(CodePermissionDenied, "permission denied")
+)
+
+// Ошибки RPC-клиента, возникающие без ответа микросервиса. Каждая из них соответствует
+// эталонной ошибке своего кода, но отличается от ошибки с тем же кодом, переданной
+// микросервисом.
+var (
+	// запрос не получил ответа в срок; соответствует ErrTimeout
+	ErrCallTimeout = &ClientError{Kind: ErrTimeout, Message: "call timeout"}
+	// брокер не смог поместить запрос в очередь микросервиса (микросервис не запущен);
+	// соответствует ErrUnavailable
+	ErrServiceUnavailable = &ClientError{Kind: ErrUnavailable, Message: "no such service"}
+)
+
+// ClientError описывает ошибку, возникшую на стороне RPC-клиента.
+type ClientError struct {
+	Kind    *RPCError // эталонная ошибка, которой соответствует ошибка клиента
+	Message string
+}
+
+// Error возвращает текстовое описание ошибки.
+func (e *ClientError) Error() string {
+	return e.Message
+}
+
+// Unwrap возвращает эталонную ошибку, которой соответствует ошибка клиента.
+func (e *ClientError) Unwrap() error {
+	return e.Kind
+}
+
+// RPCError описывает ошибку обработки запроса, передаваемую микросервисом клиенту.
+// Ошибка сериализуется в поле `error` конверта ответа и восстанавливается на стороне
+// клиента без потерь.
+type RPCError struct {
+	Code    string          `json:"code"`              // машиночитаемый код ошибки
+	Message string          `json:"message"`           // описание ошибки
+	Context string          `json:"context,omitempty"` // контекст возникновения ошибки
+	Details json.RawMessage `json:"details,omitempty"` // дополнительные сведения в JSON
+	cause   error
+}
+
+// ErrorResponse хранит данные для ответа с ошибкой.
+//
+// Deprecated: используйте RPCError.
+type ErrorResponse = RPCError
+
+// NewRPCError формирует ошибку с кодом `code` и описанием `message`.
+func NewRPCError(code, message string) *RPCError {
+	return &RPCError{Code: code, Message: message}
+}
+
+// Errorf формирует ошибку с кодом `code` и описанием по формату `format`.
+func Errorf(code, format string, args ...interface{}) *RPCError {
+	return NewRPCError(code, fmt.Sprintf(format, args...))
+}
+
+// AsRPCError преобразует произвольную ошибку `err` в RPCError.
+// Для обернутой RPCError (например, fmt.Errorf("%w: ...", ErrInvalidParams)) сохраняется ее
+// код, а описанием становится полный текст ошибки, уже включающий ее контекст, поэтому
+// контекст RPCError в этом случае не сохраняется. Ошибки, не содержащие RPCError,
+// получают код CodeInternal.
+// Пустой контекст ошибки заполняется значением `context`.
+func AsRPCError(err error, context string) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		e := *rpcErr
+		if err != error(rpcErr) {
+			e.Message = err.Error()
+			e.Context = ""
+			e.cause = err
+		}
+		if e.Context == "" {
+			e.Context = context
+		}
+		return &e
+	}
+	return &RPCError{Code: CodeInternal, Message: err.Error(), Context: context, cause: err}
+}
+
+// Error возвращает текстовое описание ошибки.
+func (e *RPCError) Error() string {
+	if e.Context != "" {
+		return e.Context + ": " + e.Message
+	}
+	return e.Message
+}
+
+// Is сообщает о совпадении кода ошибки с кодом эталонной ошибки `target`.
+func (e *RPCError) Is(target error) bool {
+	t, ok := target.(*RPCError)
+	return ok && t.Code == e.Code
+}
+
+// Unwrap возвращает исходную ошибку микросервиса.
+// На стороне клиента исходная ошибка недоступна.
+func (e *RPCError) Unwrap() error {
+	return e.cause
+}
+
+// WithDetails возвращает копию ошибки с дополнительными сведениями `details`
+// в JSON-представлении. Исходная ошибка (в т.ч. эталонная) не изменяется.
+func (e *RPCError) WithDetails(details interface{}) *RPCError {
+	c := *e
+	if data, err := json.Marshal(details); err == nil {
+		c.Details = data
+	}
+	return &c
+}
+
+// DecodeDetails декодирует дополнительные сведения об ошибке в `v`.
+func (e *RPCError) DecodeDetails(v interface{}) error {
+	if len(e.Details) == 0 {
+		return nil
+	}
+	return json.Unmarshal(e.Details, v)
+}
+
 // FailOnError печатает сообщение об ошибке в лог и останавливает выполнение процесса.
 func FailOnError(err error, context string) {
 	if err != nil {
@@ -0,0 +1,66 @@
+package microservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPCErrorCodes(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		code string
+	}{
+		{Errorf(CodePermissionDenied, "user %s", "guest"), CodePermissionDenied},
+		{fmt.Errorf("%w: no 'id' field", ErrInvalidParams), CodeInvalidParams},
+		{errors.New("disk is full"), CodeInternal},
+	} {
+		data, err := json.Marshal(NewErrorEnvelope(tc.err, "cmd"))
+		require.NoError(t, err)
+		env, err := ParseEnvelope(data)
+		require.NoError(t, err)
+		remote := env.Err()
+		assert.Equal(t, tc.code, env.Error.Code)
+		assert.Equal(t, "cmd: "+tc.err.Error(), remote.Error())
+		assert.True(t, errors.Is(remote, NewRPCError(tc.code, "")))
+	}
+	assert.False(t, errors.Is(Errorf(CodeTimeout, "x"), ErrUnavailable))
+	assert.True(t, errors.Is(fmt.Errorf("%w: no answer", ErrTimeout), ErrTimeout))
+}
+
+func TestAsRPCErrorContext(t *testing.T) {
+	inner := &RPCError{Code: CodeInvalidParams, Message: "msg", Context: "ctx"}
+	e := AsRPCError(fmt.Errorf("wrap: %w", inner), "")
+	assert.Equal(t, "wrap: ctx: msg", e.Error())
+	assert.Equal(t, CodeInvalidParams, e.Code)
+	assert.Equal(t, "cmd: wrap: ctx: msg", AsRPCError(fmt.Errorf("wrap: %w", inner), "cmd").Error())
+	assert.Equal(t, "ctx: msg", AsRPCError(inner, "cmd").Error())
+}
+
+func TestClientErrors(t *testing.T) {
+	err := fmt.Errorf("%w: no answer", ErrCallTimeout)
+	assert.ErrorIs(t, err, ErrCallTimeout)
+	assert.ErrorIs(t, err, ErrTimeout)
+	var rpcErr *RPCError
+	require.ErrorAs(t, err, &rpcErr)
+	assert.Equal(t, CodeTimeout, rpcErr.Code)
+	assert.Equal(t, CodeTimeout, AsRPCError(err, "").Code)
+
+	remote := Errorf(CodeTimeout, "database timeout")
+	assert.ErrorIs(t, remote, ErrTimeout)
+	assert.NotErrorIs(t, remote, ErrCallTimeout)
+	assert.NotErrorIs(t, Errorf(CodeUnavailable, "x"), ErrServiceUnavailable)
+	assert.ErrorIs(t, ErrServiceUnavailable, ErrUnavailable)
+}
+
+func TestRPCErrorWithDetails(t *testing.T) {
+	err := ErrInvalidParams.WithDetails(map[string]string{"field": "id"})
+	assert.Empty(t, ErrInvalidParams.Details)
+	assert.JSONEq(t, `{"field":"id"}`, string(err.Details))
+	assert.ErrorIs(t, err, ErrInvalidParams)
+	assert.NotSame(t, ErrInvalidParams, err)
+}
@@ -0,0 +1,163 @@
+// Модуль параметров точек обмена издателя и подписчика.
+//
+// По умолчанию издатель и подписчик используют точку обмена типа fanout, и подписчик
+// получает все события. Для фильтрации событий точка обмена создается с типом direct,
+// topic или headers (WithExchangeType):
+//
+//	pub := NewPublisher("tracks", WithExchangeType(amqp.ExchangeTopic))
+//	pub.Emit("application/json", data, WithRoutingKey("track.audio.updated"))
+//
+//	sub := NewSubscriber("tracks", WithExchangeType(amqp.ExchangeTopic),
+//		WithBindingKeys("track.*.updated", "album.#"))
+//
+// Для точки обмена типа headers событие публикуется с заголовками (WithHeaders), а
+// подписчик задает правила их сопоставления (WithHeaderMatch).
+//
+// По умолчанию подписчик получает события в анонимную эксклюзивную очередь, которая
+// удаляется при его отключении. Именованная подписка (WithSubscription) использует общую
+// очередь для всех экземпляров подписчика с тем же именем, которые получают события
+// поочередно. Очередь подписки сохраняется при отключении подписчиков, поэтому события,
+// опубликованные в это время, будут получены после их подключения:
+//
+//	sub := NewSubscriber("tracks", WithSubscription("indexer", QueueConfig{
+//		Durable:            true,
+//		MessageTTL:         24 * time.Hour,
+//		DeadLetterExchange: "tracks.dlx",
+//	}))
+
+package microservice
+
+import (
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// Значения аргумента x-match привязки к точке обмена типа headers.
+const (
+	HeadersMatchAll = "all" // совпадают все заголовки правила
+	HeadersMatchAny = "any" // совпадает хотя бы один заголовок правила
+)
+
+// binding описывает привязку очереди подписчика к точке обмена.
+type binding struct {
+	key  string
+	args amqp.Table
+}
+
+// exchangeConfig описывает параметры точки обмена издателя или подписчика.
+type exchangeConfig struct {
+	kind         string
+	bindings     []binding
+	subscription string      // имя подписки (пусто - анонимная очередь)
+	queue        QueueConfig // параметры очереди именованной подписки
+	retry        RetryPolicy // политика повторной обработки событий (см. consume.go)
+	prefetch     int         // число неподтвержденных событий, получаемых Consume
+}
+
+// validate проверяет тип точки обмена, режимы сопоставления заголовков, параметры очереди
+// подписки, ограничение неподтвержденных событий и политику повторов.
+func (cfg exchangeConfig) validate() error {
+	switch cfg.kind {
+	case amqp.ExchangeFanout, amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+	default:
+		return fmt.Errorf("%w: unknown exchange type '%s'", ErrInvalidConfig, cfg.kind)
+	}
+	for _, b := range cfg.bindings {
+		if match, ok := b.args["x-match"]; ok && match != HeadersMatchAll && match != HeadersMatchAny {
+			return fmt.Errorf("%w: unknown header match mode '%v'", ErrInvalidConfig, match)
+		}
+	}
+	if cfg.prefetch < 0 {
+		return fmt.Errorf("%w: negative subscriber prefetch", ErrInvalidConfig)
+	}
+	if err := cfg.retry.Validate(); err != nil {
+		return err
+	}
+	if cfg.retry.MaxRetries > 0 && !cfg.retry.Requeue && cfg.subscription == "" {
+		return fmt.Errorf("%w: retries require a named subscription", ErrInvalidConfig)
+	}
+	return cfg.queue.Validate()
+}
+
+// ExchangeOption задает параметр точки обмена издателя или подписчика при его создании.
+type ExchangeOption func(cfg *exchangeConfig)
+
+// WithExchangeType задает тип точки обмена: amqp.ExchangeFanout (по умолчанию),
+// amqp.ExchangeDirect, amqp.ExchangeTopic или amqp.ExchangeHeaders.
+func WithExchangeType(kind string) ExchangeOption {
+	return func(cfg *exchangeConfig) { cfg.kind = kind }
+}
+
+// WithBindingKeys привязывает очередь подписчика к точке обмена с ключами `keys`.
+// Для точки обмена типа topic ключи могут содержать шаблоны: `*` соответствует одному
+// слову, а `#` - любому числу слов (например, "track.*.updated").
+// Для издателя параметр не используется.
+func WithBindingKeys(keys ...string) ExchangeOption {
+	return func(cfg *exchangeConfig) {
+		for _, key := range keys {
+			cfg.bindings = append(cfg.bindings, binding{key: key})
+		}
+	}
+}
+
+// WithHeaderMatch привязывает очередь подписчика к точке обмена типа headers с правилом
+// сопоставления заголовков `headers`. Режим `match` (HeadersMatchAll, HeadersMatchAny)
+// определяет, должны ли совпасть все заголовки правила или хотя бы один; при другом
+// значении подключение подписчика завершается ошибкой ErrInvalidConfig.
+// Для издателя параметр не используется.
+func WithHeaderMatch(headers amqp.Table, match string) ExchangeOption {
+	return func(cfg *exchangeConfig) {
+		args := amqp.Table{"x-match": match}
+		for k, v := range headers {
+			args[k] = v
+		}
+		cfg.bindings = append(cfg.bindings, binding{args: args})
+	}
+}
+
+// WithSubscription задает именованную подписку `name`: очередь подписчика с этим именем
+// объявляется с параметрами `q` и используется всеми экземплярами подписчика совместно.
+// Для сохранения событий при перезапуске брокера очередь должна быть сохраняемой
+// (QueueConfig.Durable). Для издателя параметр не используется.
+func WithSubscription(name string, q QueueConfig) ExchangeOption {
+	return func(cfg *exchangeConfig) {
+		cfg.subscription = name
+		cfg.queue = q
+	}
+}
+
+// WithSubscriberPrefetch задает число событий, которые брокер передает подписчику до их
+// подтверждения в Consume (по умолчанию DefaultSubscriberPrefetch, 0 - без ограничения).
+// Для событий, подтверждаемых при получении (Receive), ограничение не действует.
+// Для издателя параметр не используется.
+func WithSubscriberPrefetch(n int) ExchangeOption {
+	return func(cfg *exchangeConfig) { cfg.prefetch = n }
+}
+
+// emitMessage описывает публикуемое событие.
+type emitMessage struct {
+	key     string
+	headers amqp.Table
+}
+
+// EmitOption задает параметр публикации события.
+type EmitOption func(m *emitMessage)
+
+// WithRoutingKey задает ключ маршрутизации события для точек обмена типов direct и topic.
+func WithRoutingKey(key string) EmitOption {
+	return func(m *emitMessage) { m.key = key }
+}
+
+// WithHeaders добавляет к событию заголовки `headers`, в том числе для маршрутизации
+// точкой обмена типа headers.
+func WithHeaders(headers amqp.Table) EmitOption {
+	return func(m *emitMessage) {
+		if m.headers == nil {
+			m.headers = amqp.Table{}
+		}
+		for k, v := range headers {
+			m.headers[k] = v
+		}
+	}
+}
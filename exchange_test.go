@@ -0,0 +1,151 @@
+package microservice
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopicMatches(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, key string
+		want         bool
+	}{
+		{"track.*.updated", "track.audio.updated", true},
+		{"track.*.updated", "track.updated", false},
+		{"track.*.updated", "track.audio.cover.updated", false},
+		{"track.#", "track", true},
+		{"track.#", "track.audio.updated", true},
+		{"#.updated", "album.updated", true},
+		{"#", "", true},
+		{"album.*", "track.audio", false},
+	} {
+		got := topicMatches(strings.Split(tc.pattern, "."), strings.Split(tc.key, "."))
+		assert.Equal(t, tc.want, got, "%s ~ %s", tc.pattern, tc.key)
+	}
+}
+
+func connectSubscriber(t *testing.T, broker *MemoryBroker, exchange string, opts ...ExchangeOption) *Subscriber {
+	sub := NewSubscriber(exchange, opts...)
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	t.Cleanup(sub.Close)
+	require.NoError(t, sub.consume(true))
+	return sub
+}
+
+// assertReceived проверяет, что подписчик получил ровно события `want`.
+func assertReceived(t *testing.T, sub *Subscriber, want ...string) {
+	t.Helper()
+	for _, body := range want {
+		select {
+		case d := <-sub.deliveries:
+			assert.Equal(t, body, string(d.Body))
+		case <-time.After(time.Second):
+			t.Fatalf("event %q is not received", body)
+		}
+	}
+	select {
+	case d := <-sub.deliveries:
+		t.Fatalf("unexpected event %q", d.Body)
+	case <-time.After(10 * time.Millisecond):
+	}
+}
+
+func TestTopicExchange(t *testing.T) {
+	broker := NewMemoryBroker()
+	topic := WithExchangeType(amqp.ExchangeTopic)
+	updates := connectSubscriber(t, broker, "tracks", topic, WithBindingKeys("track.*.updated"))
+	all := connectSubscriber(t, broker, "tracks", topic, WithBindingKeys("track.#", "album.#"))
+
+	pub := NewPublisher("tracks", topic)
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+
+	for _, key := range []string{"track.audio.updated", "track.audio.deleted", "album.updated", "artist.updated"} {
+		require.NoError(t, pub.Emit("text/plain", []byte(key), WithRoutingKey(key)))
+	}
+	assertReceived(t, updates, "track.audio.updated")
+	assertReceived(t, all, "track.audio.updated", "track.audio.deleted", "album.updated")
+
+	bad := NewPublisher("tracks", WithExchangeType("x-unknown"))
+	bad.SetTransport(broker)
+	assert.ErrorIs(t, bad.ConnectE(memoryConnStr), ErrInvalidConfig)
+}
+
+func TestHeadersExchange(t *testing.T) {
+	broker := NewMemoryBroker()
+	headers := WithExchangeType(amqp.ExchangeHeaders)
+	flac := connectSubscriber(t, broker, "media", headers,
+		WithHeaderMatch(amqp.Table{"format": "flac", "kind": "track"}, HeadersMatchAll))
+	lossless := connectSubscriber(t, broker, "media", headers,
+		WithHeaderMatch(amqp.Table{"format": "flac"}, HeadersMatchAny),
+		WithHeaderMatch(amqp.Table{"format": "ape"}, HeadersMatchAny))
+
+	pub := NewPublisher("media", headers)
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+
+	require.NoError(t, pub.Emit("text/plain", []byte("flac track"),
+		WithHeaders(amqp.Table{"format": "flac", "kind": "track"})))
+	require.NoError(t, pub.Emit("text/plain", []byte("ape album"),
+		WithHeaders(amqp.Table{"format": "ape", "kind": "album"})))
+	require.NoError(t, pub.Emit("text/plain", []byte("mp3 track"),
+		WithHeaders(amqp.Table{"format": "mp3", "kind": "track"})))
+
+	assertReceived(t, flac, "flac track")
+	assertReceived(t, lossless, "flac track", "ape album")
+	bad := NewSubscriber("media", headers, WithHeaderMatch(amqp.Table{"format": "flac"}, "some"))
+	bad.SetTransport(broker)
+	assert.ErrorIs(t, bad.ConnectE(memoryConnStr), ErrInvalidConfig)
+}
+
+func TestNamedSubscription(t *testing.T) {
+	broker := NewMemoryBroker()
+	group := WithSubscription("indexer", QueueConfig{
+		Durable: true, MaxLength: 2, DeadLetterExchange: "dlx"})
+	conn, err := broker.Dial(memoryConnStr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	require.NoError(t, ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, false, false, false, false, nil))
+	_, err = ch.QueueDeclare("dead", false, false, false, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, ch.QueueBind("dead", "", "dlx", false, nil))
+
+	first := connectSubscriber(t, broker, "tracks", group)
+	second := connectSubscriber(t, broker, "tracks", group)
+	assert.Equal(t, 2, broker.ConsumerCount("indexer"))
+
+	pub := NewPublisher("tracks")
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+	require.NoError(t, pub.Emit("text/plain", []byte("1")))
+	require.NoError(t, pub.Emit("text/plain", []byte("2")))
+	assertReceived(t, first, "1")
+	assertReceived(t, second, "2")
+
+	first.Close()
+	second.Close()
+	for _, body := range []string{"3", "4", "5"} {
+		require.NoError(t, pub.Emit("text/plain", []byte(body)))
+	}
+	assert.Equal(t, 2, broker.QueueLength("indexer"))
+	assert.Equal(t, 1, broker.QueueLength("dead"))
+
+	broker.Restart()
+	restarted := connectSubscriber(t, broker, "tracks", group)
+	assertReceived(t, restarted, "4", "5")
+
+	bad := NewSubscriber("tracks", WithSubscription("bad", QueueConfig{MaxLength: -1}))
+	bad.SetTransport(broker)
+	assert.ErrorIs(t, bad.ConnectE(memoryConnStr), ErrInvalidConfig)
+}
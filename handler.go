@@ -0,0 +1,99 @@
+// Модуль маршрутизации команд микросервиса к их обработчикам.
+
+package microservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/streadway/amqp"
+)
+
+// Request описывает контекст обрабатываемой команды.
+type Request struct {
+	ctx      context.Context
+	Cmd      string
+	Params   json.RawMessage
+	Meta     map[string]string
+	Delivery *amqp.Delivery
+	Service  *Service
+}
+
+// HandlerFunc описывает обработчик команды.
+// Результат обработки передается клиенту в поле `result` конверта ответа, а ошибка -
+// в поле `error`. Код ошибки задается возвратом *RPCError (см. Errorf) или оберткой
+// эталонной ошибки, иначе ошибке присваивается код CodeInternal.
+type HandlerFunc func(req *Request) (interface{}, error)
+
+// Context возвращает контекст исполнения запроса.
+func (req *Request) Context() context.Context {
+	if req.ctx == nil {
+		return context.Background()
+	}
+	return req.ctx
+}
+
+// Bind декодирует параметры запроса в `v`.
+// Для запросов в прежнем формате (без поля `params`) декодируется все тело запроса.
+func (req *Request) Bind(v interface{}) error {
+	if len(req.Params) != 0 {
+		return json.Unmarshal(req.Params, v)
+	}
+	return json.Unmarshal(req.Delivery.Body, v)
+}
+
+// Handle регистрирует обработчик `h` для команды `cmd`.
+// Ранее зарегистрированный для команды обработчик (в т.ч. встроенный) заменяется.
+func (s *Service) Handle(cmd string, h HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[cmd] = h
+}
+
+// HandleTyped регистрирует для команды `cmd` обработчик `h` с типизированными параметрами.
+// Параметры запроса декодируются из JSON автоматически.
+func HandleTyped[P, R any](s *Service, cmd string, h func(req *Request, params P) (R, error)) {
+	s.Handle(cmd, func(req *Request) (interface{}, error) {
+		var params P
+		if len(req.Params) != 0 {
+			if err := req.Bind(&params); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidParams, err)
+			}
+		}
+		return h(req, params)
+	})
+}
+
+// handler возвращает обработчик команды `cmd`.
+func (s *Service) handler(cmd string) (HandlerFunc, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.handlers[cmd]
+	return h, ok
+}
+
+// dispatch выполняет обработчик с промежуточными обработчиками (см. Use), отправляет
+// клиенту результат его работы и возвращает ошибку обработчика.
+func (s *Service) dispatch(h HandlerFunc, req *Request) error {
+	result, err := s.wrap(h)(req)
+	s.LogOnErrorWithContext(s.answerResult(req, result, err), "Answer")
+	return err
+}
+
+// answerResult отправляет клиенту результат `result` или ошибку `err` обработчика.
+func (s *Service) answerResult(req *Request, result interface{}, err error) error {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return s.answerPanic(req.Delivery, panicErr, req.Cmd)
+	}
+	if err != nil {
+		return s.AnswerWithErrorE(req.Delivery, err, req.Cmd)
+	}
+	env, err := NewResultEnvelope(result)
+	if err != nil {
+		return s.AnswerWithErrorE(req.Delivery, err, req.Cmd)
+	}
+	return s.AnswerEnvelope(req.Delivery, env)
+}
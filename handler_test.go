@@ -0,0 +1,22 @@
+package microservice
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlerRegistry(t *testing.T) {
+	srv := NewService(testServiceName)
+	_, ok := srv.handler("ping")
+	require.True(t, ok)
+	_, ok = srv.handler("x")
+	assert.False(t, ok)
+
+	srv.Handle("ping", func(req *Request) (interface{}, error) { return "pong", nil })
+	h, _ := srv.handler("ping")
+	result, err := h(&Request{Cmd: "ping", Service: srv})
+	require.NoError(t, err)
+	assert.Equal(t, "pong", result)
+}
@@ -0,0 +1,204 @@
+// Модуль проверки работоспособности микросервиса по http.
+//
+// Встроенный http-сервер (см. WithHealthServer) отвечает на запросы:
+//
+// - /healthz - процесс микросервиса работает
+//
+// - /readyz - микросервис готов обрабатывать запросы: подключение к брокеру установлено,
+// потребитель очереди запросов активен и пройдены проверки, зарегистрированные
+// AddHealthCheck
+//
+// - /info - сведения о микросервисе (см. ServiceInfo)
+//
+// При неуспешной проверке готовности возвращается статус 503.
+
+package microservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"runtime"
+	"sort"
+	"time"
+)
+
+// HealthCheckTimeout - срок выполнения проверок готовности.
+const HealthCheckTimeout = 5 * time.Second
+
+// Статусы проверок.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// ErrConsumerInactive возвращается проверкой готовности, если запросы не принимаются.
+var ErrConsumerInactive = errors.New("request consumer is not active")
+
+// HealthCheck описывает проверку готовности микросервиса.
+// Проверка должна завершаться до отмены контекста `ctx`.
+type HealthCheck func(ctx context.Context) error
+
+// HealthStatus описывает результат проверки готовности.
+type HealthStatus struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks,omitempty"` // результаты проверок по имени
+}
+
+// ServiceInfo описывает сведения о микросервисе.
+type ServiceInfo struct {
+	Service   string   `json:"service"`
+	State     string   `json:"state"`
+	Commands  []string `json:"commands"`
+	BuildTime string   `json:"build_time,omitempty"`
+	GoVersion string   `json:"go_version"`
+	Modules   []string `json:"modules,omitempty"`
+}
+
+type namedCheck struct {
+	name  string
+	check HealthCheck
+}
+
+// AddHealthCheck регистрирует проверку готовности `check` под именем `name`.
+// Ранее зарегистрированная проверка с тем же именем заменяется.
+func (s *Service) AddHealthCheck(name string, check HealthCheck) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, c := range s.checks {
+		if c.name == name {
+			s.checks[i].check = check
+			return
+		}
+	}
+	s.checks = append(s.checks, namedCheck{name, check})
+}
+
+// Ready выполняет проверки готовности микросервиса: подключения к брокеру ("broker"),
+// потребителя очереди запросов ("consumer") и зарегистрированные AddHealthCheck.
+func (s *Service) Ready(ctx context.Context) HealthStatus {
+	s.mu.RLock()
+	checks := append([]namedCheck{
+		{"broker", s.checkBroker},
+		{"consumer", s.checkConsumer},
+	}, s.checks...)
+	s.mu.RUnlock()
+
+	st := HealthStatus{Status: HealthOK, Checks: map[string]string{}}
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			st.Status = HealthFail
+			st.Checks[c.name] = err.Error()
+		} else {
+			st.Checks[c.name] = HealthOK
+		}
+	}
+	return st
+}
+
+func (s *Service) checkBroker(ctx context.Context) error {
+	switch s.State() {
+	case StateConnected:
+		return nil
+	case StateClosed:
+		return ErrClosed
+	default:
+		return ErrNotConnected
+	}
+}
+
+func (s *Service) checkConsumer(ctx context.Context) error {
+	s.mu.RLock()
+	running := s.running
+	s.mu.RUnlock()
+	if !running || s.isStopping() || s.State() != StateConnected {
+		return ErrConsumerInactive
+	}
+	return nil
+}
+
+// Info возвращает сведения о микросервисе.
+func (s *Service) Info() ServiceInfo {
+	s.mu.RLock()
+	commands := make([]string, 0, len(s.handlers))
+	for cmd := range s.handlers {
+		commands = append(commands, cmd)
+	}
+	s.mu.RUnlock()
+	sort.Strings(commands)
+	return ServiceInfo{
+		Service:   s.Name,
+		State:     s.State().String(),
+		Commands:  commands,
+		BuildTime: BuildTime(time.RFC3339),
+		GoVersion: runtime.Version(),
+		Modules:   Modules(),
+	}
+}
+
+// HealthHandler возвращает http-обработчик путей /healthz, /readyz и /info для
+// подключения к собственному http-серверу приложения.
+func (s *Service) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, HealthStatus{Status: HealthOK})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), HealthCheckTimeout)
+		defer cancel()
+		st := s.Ready(ctx)
+		code := http.StatusOK
+		if st.Status != HealthOK {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, st)
+	})
+	mux.HandleFunc("/info", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Info())
+	})
+	return mux
+}
+
+// HealthAddr возвращает фактический адрес встроенного http-сервера или пустую строку,
+// если сервер не запущен.
+func (s *Service) HealthAddr() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.health == nil {
+		return ""
+	}
+	return s.health.Addr
+}
+
+// startHealthServer запускает встроенный http-сервер, если задан его адрес.
+func (s *Service) startHealthServer() error {
+	if s.cfg.HealthAddr == "" {
+		return nil
+	}
+	srv, err := serveHTTP(s.cfg.HealthAddr, s.HealthHandler())
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.health = srv
+	s.mu.Unlock()
+	return nil
+}
+
+// stopHealthServer останавливает встроенный http-сервер.
+func (s *Service) stopHealthServer() {
+	s.mu.Lock()
+	srv := s.health
+	s.health = nil
+	s.mu.Unlock()
+	if srv != nil {
+		s.LogOnError(srv.Close())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,70 @@
+package microservice
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	resp, err := http.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+	return resp.StatusCode
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := NewService(testServiceName, WithHealthServer("127.0.0.1:0"))
+	srv.SetTransport(broker)
+	srv.SetBackoff(Backoff{Initial: time.Hour})
+	require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+	base := "http://" + srv.HealthAddr()
+
+	var st HealthStatus
+	assert.Equal(t, http.StatusOK, getJSON(t, base+"/healthz", &st))
+	assert.Equal(t, HealthOK, st.Status)
+
+	st = HealthStatus{}
+	assert.Equal(t, http.StatusServiceUnavailable, getJSON(t, base+"/readyz", &st))
+	assert.Equal(t, ErrConsumerInactive.Error(), st.Checks["consumer"])
+
+	go srv.Run(ctx)
+	assert.Eventually(t, func() bool {
+		return getJSON(t, base+"/readyz", &HealthStatus{}) == http.StatusOK
+	}, time.Second, time.Millisecond)
+
+	dbErr := errors.New("database is unreachable")
+	srv.AddHealthCheck("db", func(ctx context.Context) error { return dbErr })
+	st = HealthStatus{}
+	assert.Equal(t, http.StatusServiceUnavailable, getJSON(t, base+"/readyz", &st))
+	assert.Equal(t, map[string]string{"broker": HealthOK, "consumer": HealthOK, "db": dbErr.Error()}, st.Checks)
+	srv.AddHealthCheck("db", func(ctx context.Context) error { return nil })
+
+	var info ServiceInfo
+	assert.Equal(t, http.StatusOK, getJSON(t, base+"/info", &info))
+	assert.Equal(t, testServiceName, info.Service)
+	assert.Contains(t, info.Commands, "ping")
+	assert.Equal(t, StateConnected.String(), info.State)
+
+	broker.Disconnect("test")
+	assert.Eventually(t, func() bool {
+		st = HealthStatus{}
+		return getJSON(t, base+"/readyz", &st) == http.StatusServiceUnavailable
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, ErrNotConnected.Error(), st.Checks["broker"])
+
+	srv.Cleanup()
+	assert.Empty(t, srv.HealthAddr())
+	_, err := http.Get(base + "/healthz")
+	assert.Error(t, err)
+}
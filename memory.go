@@ -0,0 +1,1136 @@
+// Модуль брокера сообщений, работающего внутри процесса.
+//
+// MemoryBroker реализует транспорт компонентов пакета без внешнего брокера и предназначен
+// для тестирования. Поддерживаются:
+//
+// - точка обмена по умолчанию и точки обмена типов direct, fanout, topic и headers
+//
+// - очереди с автоматически формируемыми именами, эксклюзивные и автоматически удаляемые
+// очереди
+//
+// - потребители с ограничением числа неквитированных сообщений (QoS) и автоматическим или
+// ручным квитированием, возврат сообщений в очередь и перенаправление отклоненных
+// сообщений (dead-lettering)
+//
+// - ограничения очереди x-message-ttl и x-max-length
+//
+// - подтверждение публикаций (publisher confirms), отказ в подтверждении имитируется
+// методом NackPublishes
+//
+// - возврат неразмещенных сообщений, опубликованных с признаком mandatory
+//
+// Разрыв подключений и перезапуск брокера имитируются методами Disconnect и Restart.
+
+package microservice
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// MemoryBroker хранит состояние брокера сообщений внутри процесса.
+type MemoryBroker struct {
+	mu        sync.Mutex
+	exchanges map[string]*memExchange
+	queues    map[string]*memQueue
+	conns     map[*memConnection]struct{}
+	seq       int
+	nack      bool // отказывать в подтверждении публикаций
+}
+
+type memExchange struct {
+	name     string
+	kind     string
+	durable  bool
+	bindings []memBinding
+}
+
+type memBinding struct {
+	queue string
+	key   string
+	args  amqp.Table
+}
+
+type memMessage struct {
+	exchange    string
+	key         string
+	pub         amqp.Publishing
+	redelivered bool
+	expires     time.Time
+}
+
+type memQueue struct {
+	name         string
+	durable      bool
+	autoDelete   bool
+	owner        *memConnection
+	args         amqp.Table
+	messages     []*memMessage
+	consumers    []*memConsumer
+	next         int
+	hadConsumers bool
+	stats        QueueStats
+}
+
+// QueueStats описывает счетчики сообщений очереди MemoryBroker.
+type QueueStats struct {
+	Published int // помещено в очередь
+	Delivered int // передано потребителям, включая повторные доставки
+	Acked     int // подтверждено
+	Requeued  int // возвращено в очередь неподтвержденным
+	Rejected  int // отклонено без возврата в очередь
+}
+
+type memConnection struct {
+	broker    *MemoryBroker
+	channels  map[*memChannel]struct{}
+	closed    bool
+	listeners []chan *amqp.Error
+}
+
+type memChannel struct {
+	conn      *memConnection
+	prefetch  int
+	nextTag   uint64
+	unacked   map[uint64]*memUnacked
+	consumers map[string]*memConsumer
+	closed    bool
+	listeners []chan *amqp.Error
+
+	confirming bool
+	publishSeq uint64
+	confirms   []chan amqp.Confirmation
+	returns    []chan amqp.Return
+	notices    []interface{} // подтверждения и возвраты публикаций для передачи подписчикам
+	noticeSig  chan struct{}
+}
+
+type memUnacked struct {
+	queue    *memQueue
+	msg      *memMessage
+	consumer *memConsumer
+}
+
+type memConsumer struct {
+	tag        string
+	queue      *memQueue
+	ch         *memChannel
+	autoAck    bool
+	prefetch   int
+	unacked    int
+	outbox     []amqp.Delivery
+	signal     chan struct{}
+	stop       chan struct{}
+	deliveries chan amqp.Delivery
+}
+
+// NewMemoryBroker создает пустой брокер сообщений.
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		exchanges: map[string]*memExchange{},
+		queues:    map[string]*memQueue{},
+		conns:     map[*memConnection]struct{}{},
+	}
+	b.declareDefaults()
+	return b
+}
+
+func (b *MemoryBroker) declareDefaults() {
+	b.exchanges[""] = &memExchange{kind: amqp.ExchangeDirect, durable: true}
+}
+
+// Dial подключается к брокеру. Адрес `url` не используется.
+func (b *MemoryBroker) Dial(url string) (Connection, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	c := &memConnection{broker: b, channels: map[*memChannel]struct{}{}}
+	b.conns[c] = struct{}{}
+	return c, nil
+}
+
+// Disconnect имитирует разрыв всех подключений к брокеру с причиной `reason`.
+// Очереди, кроме эксклюзивных, и их сообщения сохраняются.
+func (b *MemoryBroker) Disconnect(reason string) {
+	b.mu.Lock()
+	err := &amqp.Error{Code: amqp.ConnectionForced, Reason: reason, Server: true}
+	var notify []func()
+	for c := range b.conns {
+		notify = append(notify, c.shutdown(err))
+	}
+	b.mu.Unlock()
+	for _, f := range notify {
+		f()
+	}
+}
+
+// Restart имитирует перезапуск брокера: разрывает все подключения и удаляет
+// несохраняемые точки обмена и очереди.
+func (b *MemoryBroker) Restart() {
+	b.Disconnect("broker restart")
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, e := range b.exchanges {
+		if !e.durable {
+			delete(b.exchanges, name)
+		}
+	}
+	for name, q := range b.queues {
+		if !q.durable {
+			delete(b.queues, name)
+		}
+	}
+	for _, e := range b.exchanges {
+		bindings := e.bindings[:0]
+		for _, bnd := range e.bindings {
+			if _, ok := b.queues[bnd.queue]; ok {
+				bindings = append(bindings, bnd)
+			}
+		}
+		e.bindings = bindings
+	}
+}
+
+// NackPublishes включает (`on`) или выключает отказ в подтверждении публикаций каналам
+// в режиме подтверждений. Отклоненные сообщения не направляются в очереди.
+func (b *MemoryBroker) NackPublishes(on bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nack = on
+}
+
+// QueueLength возвращает число сообщений, ожидающих доставки в очереди `name`, или -1 для
+// несуществующей очереди.
+func (b *MemoryBroker) QueueLength(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	q, ok := b.queues[name]
+	if !ok {
+		return -1
+	}
+	q.expire(b)
+	return len(q.messages)
+}
+
+// ConsumerCount возвращает число потребителей очереди `name`.
+func (b *MemoryBroker) ConsumerCount(name string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[name]; ok {
+		return len(q.consumers)
+	}
+	return 0
+}
+
+// Stats возвращает счетчики сообщений очереди `name`.
+func (b *MemoryBroker) Stats(name string) QueueStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if q, ok := b.queues[name]; ok {
+		return q.stats
+	}
+	return QueueStats{}
+}
+
+// route возвращает очереди, в которые направляется сообщение.
+func (b *MemoryBroker) route(e *memExchange, key string, pub amqp.Publishing) []*memQueue {
+	if e.name == "" {
+		if q, ok := b.queues[key]; ok {
+			return []*memQueue{q}
+		}
+		return nil
+	}
+	var queues []*memQueue
+	seen := map[string]bool{}
+	for _, bnd := range e.bindings {
+		if seen[bnd.queue] || !e.matches(bnd, key, pub) {
+			continue
+		}
+		if q, ok := b.queues[bnd.queue]; ok {
+			seen[bnd.queue] = true
+			queues = append(queues, q)
+		}
+	}
+	return queues
+}
+
+// publish направляет сообщение в очереди точки обмена `exchange` и сообщает, было ли
+// сообщение помещено хотя бы в одну очередь.
+func (b *MemoryBroker) publish(exchange, key string, pub amqp.Publishing) (bool, error) {
+	e, ok := b.exchanges[exchange]
+	if !ok {
+		return false, &amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange),
+		}
+	}
+	queues := b.route(e, key, pub)
+	for _, q := range queues {
+		q.enqueue(b, &memMessage{exchange: exchange, key: key, pub: copyPublishing(pub)})
+	}
+	return len(queues) > 0, nil
+}
+
+func (e *memExchange) matches(bnd memBinding, key string, pub amqp.Publishing) bool {
+	switch e.kind {
+	case amqp.ExchangeFanout:
+		return true
+	case amqp.ExchangeTopic:
+		return topicMatches(strings.Split(bnd.key, "."), strings.Split(key, "."))
+	case amqp.ExchangeHeaders:
+		return headersMatch(bnd.args, pub.Headers)
+	default:
+		return bnd.key == key
+	}
+}
+
+// topicMatches сопоставляет слова ключа маршрутизации `words` с шаблоном `pattern`:
+// `*` соответствует одному слову, `#` - любому числу слов.
+func topicMatches(pattern, words []string) bool {
+	if len(pattern) == 0 {
+		return len(words) == 0
+	}
+	switch pattern[0] {
+	case "#":
+		for i := 0; i <= len(words); i++ {
+			if topicMatches(pattern[1:], words[i:]) {
+				return true
+			}
+		}
+		return false
+	case "*":
+		return len(words) > 0 && topicMatches(pattern[1:], words[1:])
+	default:
+		return len(words) > 0 && pattern[0] == words[0] && topicMatches(pattern[1:], words[1:])
+	}
+}
+
+// headersMatch сопоставляет заголовки сообщения `headers` с аргументами привязки `args`.
+// Аргументы с префиксом `x-` не сопоставляются, а аргумент без значения требует только
+// наличия заголовка.
+func headersMatch(args, headers amqp.Table) bool {
+	matchAny := args["x-match"] == HeadersMatchAny
+	for k, want := range args {
+		if strings.HasPrefix(k, "x-") {
+			continue
+		}
+		got, ok := headers[k]
+		matched := ok && (want == nil || fmt.Sprint(got) == fmt.Sprint(want))
+		if matchAny && matched {
+			return true
+		}
+		if !matchAny && !matched {
+			return false
+		}
+	}
+	return !matchAny
+}
+
+func copyPublishing(pub amqp.Publishing) amqp.Publishing {
+	if pub.Headers != nil {
+		headers := amqp.Table{}
+		for k, v := range pub.Headers {
+			headers[k] = v
+		}
+		pub.Headers = headers
+	}
+	pub.Body = append([]byte(nil), pub.Body...)
+	return pub
+}
+
+// ttl возвращает время жизни сообщения с учетом параметров очереди и сообщения.
+func (q *memQueue) ttl(pub amqp.Publishing) (time.Duration, bool) {
+	var ttl time.Duration
+	ok := false
+	if ms, set := tableInt(q.args, "x-message-ttl"); set {
+		ttl, ok = time.Duration(ms)*time.Millisecond, true
+	}
+	if pub.Expiration != "" {
+		if ms, err := strconv.ParseInt(pub.Expiration, 10, 64); err == nil {
+			if d := time.Duration(ms) * time.Millisecond; !ok || d < ttl {
+				ttl, ok = d, true
+			}
+		}
+	}
+	return ttl, ok
+}
+
+// enqueue помещает сообщение в очередь с учетом ее ограничений.
+func (q *memQueue) enqueue(b *MemoryBroker, msg *memMessage) {
+	if ttl, ok := q.ttl(msg.pub); ok {
+		msg.expires = time.Now().Add(ttl)
+		time.AfterFunc(ttl, func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			q.expire(b)
+		})
+	}
+	q.messages = append(q.messages, msg)
+	q.stats.Published++
+	if max, ok := tableInt(q.args, "x-max-length"); ok {
+		for int64(len(q.messages)) > max {
+			head := q.messages[0]
+			q.messages = q.messages[1:]
+			q.deadLetter(b, head, "maxlen")
+		}
+	}
+	q.dispatch(b)
+}
+
+// requeue возвращает сообщение в начало очереди.
+func (q *memQueue) requeue(b *MemoryBroker, msg *memMessage) {
+	msg.redelivered = true
+	q.messages = append([]*memMessage{msg}, q.messages...)
+}
+
+// expire удаляет из очереди сообщения с истекшим временем жизни.
+func (q *memQueue) expire(b *MemoryBroker) {
+	now := time.Now()
+	alive := q.messages[:0]
+	var expired []*memMessage
+	for _, msg := range q.messages {
+		if !msg.expires.IsZero() && !now.Before(msg.expires) {
+			expired = append(expired, msg)
+		} else {
+			alive = append(alive, msg)
+		}
+	}
+	q.messages = alive
+	for _, msg := range expired {
+		q.deadLetter(b, msg, "expired")
+	}
+}
+
+// deadLetter перенаправляет сообщение в точку обмена x-dead-letter-exchange очереди,
+// добавляя сведения в заголовок x-death. При отсутствии точки обмена сообщение удаляется.
+func (q *memQueue) deadLetter(b *MemoryBroker, msg *memMessage, reason string) {
+	dlx, ok := q.args["x-dead-letter-exchange"].(string)
+	if !ok {
+		return
+	}
+	key := msg.key
+	if dlk, ok := q.args["x-dead-letter-routing-key"].(string); ok {
+		key = dlk
+	}
+	pub := copyPublishing(msg.pub)
+	if pub.Headers == nil {
+		pub.Headers = amqp.Table{}
+	}
+	pub.Headers["x-death"] = addDeath(pub.Headers["x-death"], amqp.Table{
+		"queue":        q.name,
+		"reason":       reason,
+		"exchange":     msg.exchange,
+		"routing-keys": []interface{}{msg.key},
+		"time":         time.Now(),
+	})
+	pub.Expiration = ""
+	b.publish(dlx, key, pub)
+}
+
+// addDeath обновляет заголовок x-death: счетчик записи с той же очередью и причиной
+// увеличивается, а запись перемещается в начало списка.
+func addDeath(header interface{}, death amqp.Table) []interface{} {
+	deaths, _ := header.([]interface{})
+	count := int64(1)
+	rest := make([]interface{}, 0, len(deaths))
+	for _, d := range deaths {
+		t, ok := d.(amqp.Table)
+		if ok && t["queue"] == death["queue"] && t["reason"] == death["reason"] {
+			n, _ := tableInt(t, "count")
+			count = n + 1
+			continue
+		}
+		rest = append(rest, d)
+	}
+	death["count"] = count
+	return append([]interface{}{death}, rest...)
+}
+
+// dispatch распределяет сообщения очереди между потребителями, у которых не превышено
+// ограничение числа неквитированных сообщений.
+func (q *memQueue) dispatch(b *MemoryBroker) {
+	for len(q.messages) > 0 {
+		c := q.nextConsumer()
+		if c == nil {
+			return
+		}
+		msg := q.messages[0]
+		q.messages = q.messages[1:]
+		if !msg.expires.IsZero() && !time.Now().Before(msg.expires) {
+			q.deadLetter(b, msg, "expired")
+			continue
+		}
+		q.stats.Delivered++
+		c.deliver(msg)
+	}
+}
+
+func (q *memQueue) nextConsumer() *memConsumer {
+	for i := 0; i < len(q.consumers); i++ {
+		c := q.consumers[(q.next+i)%len(q.consumers)]
+		if c.autoAck || c.prefetch <= 0 || c.unacked < c.prefetch {
+			q.next = (q.next + i + 1) % len(q.consumers)
+			return c
+		}
+	}
+	return nil
+}
+
+func (q *memQueue) removeConsumer(b *MemoryBroker, c *memConsumer) {
+	for i, qc := range q.consumers {
+		if qc == c {
+			q.consumers = append(q.consumers[:i], q.consumers[i+1:]...)
+			break
+		}
+	}
+	if q.next >= len(q.consumers) {
+		q.next = 0
+	}
+	if q.autoDelete && q.hadConsumers && len(q.consumers) == 0 {
+		b.deleteQueue(q)
+	}
+}
+
+func (b *MemoryBroker) deleteQueue(q *memQueue) {
+	if b.queues[q.name] != q {
+		return
+	}
+	delete(b.queues, q.name)
+	for _, e := range b.exchanges {
+		bindings := e.bindings[:0]
+		for _, bnd := range e.bindings {
+			if bnd.queue != q.name {
+				bindings = append(bindings, bnd)
+			}
+		}
+		e.bindings = bindings
+	}
+}
+
+// deliver передает сообщение потребителю.
+func (c *memConsumer) deliver(msg *memMessage) {
+	ch := c.ch
+	ch.nextTag++
+	tag := ch.nextTag
+	if !c.autoAck {
+		ch.unacked[tag] = &memUnacked{queue: c.queue, msg: msg, consumer: c}
+		c.unacked++
+	}
+	pub := msg.pub
+	c.outbox = append(c.outbox, amqp.Delivery{
+		Acknowledger:    ch,
+		Headers:         pub.Headers,
+		ContentType:     pub.ContentType,
+		ContentEncoding: pub.ContentEncoding,
+		DeliveryMode:    pub.DeliveryMode,
+		Priority:        pub.Priority,
+		CorrelationId:   pub.CorrelationId,
+		ReplyTo:         pub.ReplyTo,
+		Expiration:      pub.Expiration,
+		MessageId:       pub.MessageId,
+		Timestamp:       pub.Timestamp,
+		Type:            pub.Type,
+		UserId:          pub.UserId,
+		AppId:           pub.AppId,
+		ConsumerTag:     c.tag,
+		DeliveryTag:     tag,
+		Redelivered:     msg.redelivered,
+		Exchange:        msg.exchange,
+		RoutingKey:      msg.key,
+		Body:            pub.Body,
+	})
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run передает сообщения из исходящей очереди потребителя в его go-канал.
+func (c *memConsumer) run(b *MemoryBroker) {
+	defer close(c.deliveries)
+	for {
+		b.mu.Lock()
+		if len(c.outbox) == 0 {
+			b.mu.Unlock()
+			select {
+			case <-c.signal:
+				continue
+			case <-c.stop:
+				return
+			}
+		}
+		d := c.outbox[0]
+		c.outbox = c.outbox[1:]
+		b.mu.Unlock()
+		select {
+		case c.deliveries <- d:
+		case <-c.stop:
+			b.mu.Lock()
+			c.giveBack([]amqp.Delivery{d})
+			b.mu.Unlock()
+			return
+		}
+	}
+}
+
+// giveBack возвращает в очередь сообщения, которые не были переданы потребителю.
+// Вызывается под блокировкой брокера.
+func (c *memConsumer) giveBack(deliveries []amqp.Delivery) {
+	for i := len(deliveries) - 1; i >= 0; i-- {
+		d := deliveries[i]
+		if u, ok := c.ch.unacked[d.DeliveryTag]; ok {
+			delete(c.ch.unacked, d.DeliveryTag)
+			c.unacked--
+			u.queue.requeue(c.ch.conn.broker, u.msg)
+		} else if c.autoAck {
+			c.queue.requeue(c.ch.conn.broker, &memMessage{
+				exchange: d.Exchange, key: d.RoutingKey, pub: publishingOf(d)})
+		}
+	}
+}
+
+func publishingOf(d amqp.Delivery) amqp.Publishing {
+	return amqp.Publishing{
+		Headers:         d.Headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    d.DeliveryMode,
+		Priority:        d.Priority,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		Expiration:      d.Expiration,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Type:            d.Type,
+		UserId:          d.UserId,
+		AppId:           d.AppId,
+		Body:            d.Body,
+	}
+}
+
+// cancel отменяет потребителя. Вызывается под блокировкой брокера.
+func (c *memConsumer) cancel() {
+	delete(c.ch.consumers, c.tag)
+	c.giveBack(c.outbox)
+	c.outbox = nil
+	close(c.stop)
+	c.queue.removeConsumer(c.ch.conn.broker, c)
+	c.queue.dispatch(c.ch.conn.broker)
+}
+
+// Channel открывает новый канал.
+func (c *memConnection) Channel() (Channel, error) {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &memChannel{
+		conn:      c,
+		unacked:   map[uint64]*memUnacked{},
+		consumers: map[string]*memConsumer{},
+		noticeSig: make(chan struct{}, 1),
+	}
+	c.channels[ch] = struct{}{}
+	go ch.runNotices(b)
+	return ch, nil
+}
+
+// NotifyClose регистрирует канал оповещения о закрытии подключения.
+func (c *memConnection) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	b := c.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if c.closed {
+		close(receiver)
+		return receiver
+	}
+	c.listeners = append(c.listeners, receiver)
+	return receiver
+}
+
+// Close закрывает подключение.
+func (c *memConnection) Close() error {
+	b := c.broker
+	b.mu.Lock()
+	if c.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	notify := c.shutdown(nil)
+	b.mu.Unlock()
+	notify()
+	return nil
+}
+
+// shutdown закрывает подключение и его каналы под блокировкой брокера и возвращает функцию
+// оповещения подписчиков, которая вызывается после снятия блокировки.
+func (c *memConnection) shutdown(err *amqp.Error) func() {
+	b := c.broker
+	c.closed = true
+	delete(b.conns, c)
+	var notify []func()
+	for ch := range c.channels {
+		notify = append(notify, ch.shutdown(err))
+	}
+	for _, q := range b.queues {
+		if q.owner == c {
+			b.deleteQueue(q)
+		}
+	}
+	listeners := c.listeners
+	c.listeners = nil
+	return func() {
+		for _, f := range notify {
+			f()
+		}
+		notifyClosed(listeners, err)
+	}
+}
+
+func notifyClosed(listeners []chan *amqp.Error, err *amqp.Error) {
+	for _, l := range listeners {
+		if err != nil {
+			select {
+			case l <- err:
+			default:
+			}
+		}
+		close(l)
+	}
+}
+
+// shutdown закрывает канал под блокировкой брокера: отменяет потребителей и возвращает
+// в очереди неквитированные сообщения.
+func (ch *memChannel) shutdown(err *amqp.Error) func() {
+	b := ch.conn.broker
+	ch.closed = true
+	delete(ch.conn.channels, ch)
+	for _, c := range ch.consumers {
+		c.cancel()
+	}
+	for tag := range ch.unacked {
+		ch.settle(tag, true, false)
+	}
+	for _, q := range b.queues {
+		q.dispatch(b)
+	}
+	listeners := ch.listeners
+	ch.listeners = nil
+	ch.signalNotices()
+	return func() { notifyClosed(listeners, err) }
+}
+
+// settle завершает обработку неквитированного сообщения `tag`: удаляет его после
+// подтверждения, возвращает в очередь или перенаправляет отклоненное сообщение.
+func (ch *memChannel) settle(tag uint64, requeue, ack bool) {
+	u, ok := ch.unacked[tag]
+	if !ok {
+		return
+	}
+	b := ch.conn.broker
+	delete(ch.unacked, tag)
+	u.consumer.unacked--
+	switch {
+	case ack:
+		u.queue.stats.Acked++
+	case requeue:
+		u.queue.stats.Requeued++
+		u.queue.requeue(b, u.msg)
+	default:
+		u.queue.stats.Rejected++
+		u.queue.deadLetter(b, u.msg, "rejected")
+	}
+	u.queue.dispatch(b)
+}
+
+// settleTags завершает обработку сообщения `tag` или, при `multiple`, всех сообщений
+// с меньшими номерами.
+func (ch *memChannel) settleTags(tag uint64, multiple, requeue, ack bool) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if !multiple {
+		if _, ok := ch.unacked[tag]; !ok {
+			return &amqp.Error{
+				Code:   amqp.PreconditionFailed,
+				Reason: fmt.Sprintf("PRECONDITION_FAILED - unknown delivery tag %d", tag),
+			}
+		}
+		ch.settle(tag, requeue, ack)
+		return nil
+	}
+	for t := range ch.unacked {
+		if t <= tag {
+			ch.settle(t, requeue, ack)
+		}
+	}
+	return nil
+}
+
+// Ack подтверждает обработку сообщения.
+func (ch *memChannel) Ack(tag uint64, multiple bool) error {
+	return ch.settleTags(tag, multiple, false, true)
+}
+
+// Nack отклоняет сообщение с возвратом в очередь или перенаправлением.
+func (ch *memChannel) Nack(tag uint64, multiple, requeue bool) error {
+	return ch.settleTags(tag, multiple, requeue, false)
+}
+
+// Reject отклоняет сообщение с возвратом в очередь или перенаправлением.
+func (ch *memChannel) Reject(tag uint64, requeue bool) error {
+	return ch.settleTags(tag, false, requeue, false)
+}
+
+// ExchangeDeclare объявляет точку обмена.
+func (ch *memChannel) ExchangeDeclare(
+	name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	switch kind {
+	case amqp.ExchangeDirect, amqp.ExchangeFanout, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+	default:
+		return &amqp.Error{
+			Code:   amqp.NotImplemented,
+			Reason: fmt.Sprintf("NOT_IMPLEMENTED - exchange type '%s'", kind),
+		}
+	}
+	if e, ok := b.exchanges[name]; ok {
+		if e.kind != kind {
+			return &amqp.Error{
+				Code:   amqp.PreconditionFailed,
+				Reason: fmt.Sprintf("PRECONDITION_FAILED - inequivalent type for exchange '%s'", name),
+			}
+		}
+		return nil
+	}
+	b.exchanges[name] = &memExchange{name: name, kind: kind, durable: durable}
+	return nil
+}
+
+// QueueDeclare объявляет очередь. Для пустого имени формируется уникальное имя.
+func (ch *memChannel) QueueDeclare(
+	name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error) {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.Queue{}, amqp.ErrClosed
+	}
+	if name == "" {
+		b.seq++
+		name = fmt.Sprintf("amq.gen-%d", b.seq)
+	}
+	q, ok := b.queues[name]
+	if ok {
+		if q.owner != nil && q.owner != ch.conn {
+			return amqp.Queue{}, &amqp.Error{
+				Code:   amqp.ResourceLocked,
+				Reason: fmt.Sprintf("RESOURCE_LOCKED - exclusive queue '%s'", name),
+			}
+		}
+	} else {
+		q = &memQueue{name: name, durable: durable, autoDelete: autoDelete, args: args}
+		if exclusive {
+			q.owner = ch.conn
+		}
+		b.queues[name] = q
+	}
+	return amqp.Queue{Name: name, Messages: len(q.messages), Consumers: len(q.consumers)}, nil
+}
+
+// QueueBind связывает очередь с точкой обмена.
+func (ch *memChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	e, ok := b.exchanges[exchange]
+	if !ok || exchange == "" {
+		return &amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no exchange '%s'", exchange),
+		}
+	}
+	if _, ok := b.queues[name]; !ok {
+		return &amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", name),
+		}
+	}
+	e.bindings = append(e.bindings, memBinding{queue: name, key: key, args: args})
+	return nil
+}
+
+// Qos задает ограничение числа неквитированных сообщений для последующих потребителей.
+func (ch *memChannel) Qos(prefetchCount, prefetchSize int, global bool) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.prefetch = prefetchCount
+	return nil
+}
+
+// Consume регистрирует потребителя очереди.
+func (ch *memChannel) Consume(
+	queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table,
+) (<-chan amqp.Delivery, error) {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	q, ok := b.queues[queue]
+	if !ok {
+		return nil, &amqp.Error{
+			Code:   amqp.NotFound,
+			Reason: fmt.Sprintf("NOT_FOUND - no queue '%s'", queue),
+		}
+	}
+	if consumer == "" {
+		b.seq++
+		consumer = fmt.Sprintf("amq.ctag-%d", b.seq)
+	}
+	if _, ok := ch.consumers[consumer]; ok {
+		return nil, &amqp.Error{
+			Code:   amqp.NotAllowed,
+			Reason: fmt.Sprintf("NOT_ALLOWED - duplicate consumer tag '%s'", consumer),
+		}
+	}
+	c := &memConsumer{
+		tag:        consumer,
+		queue:      q,
+		ch:         ch,
+		autoAck:    autoAck,
+		prefetch:   ch.prefetch,
+		signal:     make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+		deliveries: make(chan amqp.Delivery),
+	}
+	ch.consumers[consumer] = c
+	q.consumers = append(q.consumers, c)
+	q.hadConsumers = true
+	go c.run(b)
+	q.dispatch(b)
+	return c.deliveries, nil
+}
+
+// Cancel отменяет потребителя. Сообщения, не переданные потребителю, возвращаются в очередь.
+func (ch *memChannel) Cancel(consumer string, noWait bool) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if c, ok := ch.consumers[consumer]; ok {
+		c.cancel()
+	}
+	return nil
+}
+
+// Publish публикует сообщение в точку обмена `exchange` с ключом маршрутизации `key`.
+func (ch *memChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	if ch.confirming && b.nack {
+		ch.confirm(false)
+		return nil
+	}
+	routed, err := b.publish(exchange, key, msg)
+	if err != nil {
+		return err
+	}
+	if mandatory && !routed {
+		ch.notices = append(ch.notices, returnOf(exchange, key, msg))
+		ch.signalNotices()
+	}
+	if ch.confirming {
+		ch.confirm(true)
+	}
+	return nil
+}
+
+// returnOf формирует возврат неразмещенного сообщения.
+func returnOf(exchange, key string, pub amqp.Publishing) amqp.Return {
+	return amqp.Return{
+		ReplyCode:       amqp.NoRoute,
+		ReplyText:       "NO_ROUTE",
+		Exchange:        exchange,
+		RoutingKey:      key,
+		ContentType:     pub.ContentType,
+		ContentEncoding: pub.ContentEncoding,
+		Headers:         pub.Headers,
+		DeliveryMode:    pub.DeliveryMode,
+		Priority:        pub.Priority,
+		CorrelationId:   pub.CorrelationId,
+		ReplyTo:         pub.ReplyTo,
+		Expiration:      pub.Expiration,
+		MessageId:       pub.MessageId,
+		Timestamp:       pub.Timestamp,
+		Type:            pub.Type,
+		UserId:          pub.UserId,
+		AppId:           pub.AppId,
+		Body:            pub.Body,
+	}
+}
+
+// Confirm переводит канал в режим подтверждения публикаций.
+func (ch *memChannel) Confirm(noWait bool) error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	ch.confirming = true
+	return nil
+}
+
+// NotifyPublish регистрирует канал получения подтверждений публикаций.
+func (ch *memChannel) NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		close(confirm)
+		return confirm
+	}
+	ch.confirms = append(ch.confirms, confirm)
+	return confirm
+}
+
+// NotifyReturn регистрирует канал получения сообщений, опубликованных с признаком
+// mandatory и не размещенных ни в одной очереди.
+func (ch *memChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		close(c)
+		return c
+	}
+	ch.returns = append(ch.returns, c)
+	return c
+}
+
+// confirm подтверждает (`ack`) очередную публикацию или отказывает в подтверждении.
+// Вызывается под блокировкой брокера.
+func (ch *memChannel) confirm(ack bool) {
+	ch.publishSeq++
+	ch.notices = append(ch.notices, amqp.Confirmation{DeliveryTag: ch.publishSeq, Ack: ack})
+	ch.signalNotices()
+}
+
+func (ch *memChannel) signalNotices() {
+	select {
+	case ch.noticeSig <- struct{}{}:
+	default:
+	}
+}
+
+// runNotices передает подтверждения и возвраты публикаций подписчикам в порядке
+// публикации и закрывает каналы подписчиков после закрытия канала.
+func (ch *memChannel) runNotices(b *MemoryBroker) {
+	for {
+		b.mu.Lock()
+		if len(ch.notices) == 0 {
+			if ch.closed {
+				confirms, returns := ch.confirms, ch.returns
+				ch.confirms, ch.returns = nil, nil
+				b.mu.Unlock()
+				for _, c := range confirms {
+					close(c)
+				}
+				for _, c := range returns {
+					close(c)
+				}
+				return
+			}
+			b.mu.Unlock()
+			<-ch.noticeSig
+			continue
+		}
+		notice := ch.notices[0]
+		ch.notices = ch.notices[1:]
+		confirms := append([]chan amqp.Confirmation(nil), ch.confirms...)
+		returns := append([]chan amqp.Return(nil), ch.returns...)
+		b.mu.Unlock()
+		switch n := notice.(type) {
+		case amqp.Confirmation:
+			for _, c := range confirms {
+				c <- n
+			}
+		case amqp.Return:
+			for _, c := range returns {
+				c <- n
+			}
+		}
+	}
+}
+
+// NotifyClose регистрирует канал оповещения о закрытии канала.
+func (ch *memChannel) NotifyClose(receiver chan *amqp.Error) chan *amqp.Error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ch.closed {
+		close(receiver)
+		return receiver
+	}
+	ch.listeners = append(ch.listeners, receiver)
+	return receiver
+}
+
+// Close закрывает канал.
+func (ch *memChannel) Close() error {
+	b := ch.conn.broker
+	b.mu.Lock()
+	if ch.closed {
+		b.mu.Unlock()
+		return amqp.ErrClosed
+	}
+	notify := ch.shutdown(nil)
+	b.mu.Unlock()
+	notify()
+	return nil
+}
+
+// tableInt возвращает целочисленное значение аргумента `key`.
+func tableInt(t amqp.Table, key string) (int64, bool) {
+	switch v := t[key].(type) {
+	case int:
+		return int64(v), true
+	case int16:
+		return int64(v), true
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,142 @@
+package microservice
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const memoryConnStr = "memory://"
+
+func startMemoryService(ctx context.Context, t *testing.T, broker *MemoryBroker) *Service {
+	srv := NewService(testServiceName)
+	srv.SetTransport(broker)
+	srv.SetBackoff(Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond, Multiplier: 2})
+	require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+	go srv.Run(ctx)
+	return srv
+}
+
+func TestMemoryServiceCommands(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := startMemoryService(ctx, t, broker)
+	defer srv.Cleanup()
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Second, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+
+	ping, err := Call[interface{}, PingResult](ctx, cl, testServiceName, "ping", nil)
+	require.NoError(t, err)
+	assert.Equal(t, testServiceName, ping.Service)
+
+	_, err = Call[interface{}, PingResult](ctx, cl, testServiceName, "x", nil)
+	assert.ErrorIs(t, err, ErrUnknownCommand)
+
+	correlationID, _, _ := CreateCmdRequest("")
+	require.NoError(t, cl.RequestE(testServiceName, correlationID, []byte("{")))
+	resp, err := ParseErrorAnswer(cl.Result(correlationID))
+	require.NoError(t, err)
+	assert.ErrorIs(t, resp, ErrInvalidParams)
+
+	assert.Equal(t, 0, broker.QueueLength(testServiceName))
+}
+
+func TestMemorySubscribing(t *testing.T) {
+	broker := NewMemoryBroker()
+	sub := NewSubscriber("test")
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	defer sub.Close()
+
+	pub := NewPublisher("test")
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+
+	require.NoError(t, sub.consume(true))
+	require.NoError(t, pub.Emit("text/plain", []byte("Hello")))
+	delivery, err := sub.ReceiveOnceE()
+	require.NoError(t, err)
+	assert.Equal(t, []byte("Hello"), delivery.Body)
+}
+
+func TestMemoryBrokerAcks(t *testing.T) {
+	broker := NewMemoryBroker()
+	conn, err := broker.Dial(memoryConnStr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+
+	require.NoError(t, ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, false, false, false, false, nil))
+	_, err = ch.QueueDeclare("dead", false, false, false, false, nil)
+	require.NoError(t, err)
+	require.NoError(t, ch.QueueBind("dead", "", "dlx", false, nil))
+	_, err = ch.QueueDeclare("work", false, false, false, false, amqp.Table{"x-dead-letter-exchange": "dlx"})
+	require.NoError(t, err)
+
+	require.NoError(t, ch.Qos(1, 0, false))
+	msgs, err := ch.Consume("work", "c", false, false, false, false, nil)
+	require.NoError(t, err)
+	for _, body := range []string{"a", "b"} {
+		require.NoError(t, ch.Publish("", "work", false, false, amqp.Publishing{Body: []byte(body)}))
+	}
+
+	d := <-msgs
+	assert.Equal(t, "a", string(d.Body))
+	assert.Equal(t, 1, broker.QueueLength("work"), "prefetch limits unacked messages")
+	require.NoError(t, d.Nack(false, true))
+
+	d = <-msgs
+	assert.Equal(t, "a", string(d.Body))
+	assert.True(t, d.Redelivered)
+	require.NoError(t, d.Reject(false))
+
+	d = <-msgs
+	assert.Equal(t, "b", string(d.Body))
+	require.NoError(t, d.Ack(false))
+	assert.Error(t, d.Ack(false))
+
+	require.Equal(t, 1, broker.QueueLength("dead"))
+	dead, err := ch.Consume("dead", "", true, false, false, false, nil)
+	require.NoError(t, err)
+	d = <-dead
+	assert.Equal(t, "a", string(d.Body))
+	deaths, ok := d.Headers["x-death"].([]interface{})
+	require.True(t, ok)
+	death := deaths[0].(amqp.Table)
+	assert.Equal(t, "work", death["queue"])
+	assert.Equal(t, "rejected", death["reason"])
+	assert.Equal(t, int64(1), death["count"])
+}
+
+func TestMemoryBrokerReconnect(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := startMemoryService(ctx, t, broker)
+	defer srv.Cleanup()
+	events := srv.NotifyState(make(chan ConnEvent, 10))
+
+	broker.Restart()
+	for ev := range events {
+		if ev.State == StateConnected {
+			break
+		}
+	}
+	assert.Equal(t, 1, broker.ConsumerCount(testServiceName))
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Second, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+	ping, err := Call[interface{}, PingResult](ctx, cl, testServiceName, "ping", nil)
+	require.NoError(t, err)
+	assert.Equal(t, testServiceName, ping.Service)
+}
@@ -0,0 +1,243 @@
+// Модуль сбора метрик компонентов пакета в формате Prometheus.
+//
+// Сбор метрик не обязателен и включается методом EnableMetrics компонента (Service,
+// RPCClient, Publisher, WebPoller) с указанием реестра метрик prometheus. Метрики реестра
+// отдаются по http функцией ServeMetrics:
+//
+//	reg := prometheus.NewRegistry()
+//	srv.EnableMetrics(reg)
+//	microservice.ServeMetrics("127.0.0.1:9100", reg) // http://127.0.0.1:9100/metrics
+//
+// Собираются следующие метрики:
+//
+// - ds_service_requests_total, ds_service_request_duration_seconds,
+// ds_service_requests_in_flight, ds_service_errors_total - обработка команд микросервисом
+//
+// - ds_client_call_duration_seconds, ds_client_timeouts_total - вызовы RPC-клиента
+//
+// - ds_publisher_emitted_total, ds_publisher_errors_total - публикация событий
+//
+// - ds_webpoller_queue_depth, ds_webpoller_request_duration_seconds - опрос http-ресурсов
+
+package microservice
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// ServeMetrics запускает http-сервер, отдающий метрики реестра `g` по пути /metrics, на
+// адресе `addr` (например, "127.0.0.1:9100"). Фактический адрес сервера (в т.ч. для порта 0)
+// доступен в поле Addr возвращаемого сервера, остановка выполняется его методами Close и
+// Shutdown.
+func ServeMetrics(addr string, g prometheus.Gatherer) (*http.Server, error) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(g, promhttp.HandlerOpts{}))
+	return serveHTTP(addr, mux)
+}
+
+// ServeMetricsConfig запускает http-сервер метрик реестра `g` с параметрами `cfg`
+// (см. LoadConfig). Если адрес не задан, сервер не запускается и возвращается nil.
+func ServeMetricsConfig(cfg MetricsConfig, g prometheus.Gatherer) (*http.Server, error) {
+	if cfg.Addr == "" {
+		return nil, nil
+	}
+	return ServeMetrics(cfg.Addr, g)
+}
+
+// serveHTTP запускает http-сервер с обработчиком `h` на адресе `addr`.
+func serveHTTP(addr string, h http.Handler) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: h}
+	go srv.Serve(ln)
+	return srv, nil
+}
+
+// register регистрирует метрику `c` в реестре `reg`. Если такая же метрика уже
+// зарегистрирована (например, другим компонентом), возвращается ранее зарегистрированная,
+// поэтому один реестр может использоваться несколькими компонентами. Регистрация метрики
+// с тем же именем, но другим типом или набором меток, считается ошибкой программы.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	err := reg.Register(c)
+	if err == nil {
+		return c
+	}
+	var are prometheus.AlreadyRegisteredError
+	if errors.As(err, &are) {
+		if existing, ok := are.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	panic(err)
+}
+
+// Значения метки cmd для запросов, не дошедших до обработчика команды.
+const (
+	metricsUnknownCmd   = "_unknown"
+	metricsMalformedCmd = "_malformed"
+)
+
+// serviceMetrics хранит метрики обработки команд микросервисом.
+type serviceMetrics struct {
+	service  string
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+	errors   *prometheus.CounterVec
+}
+
+// EnableMetrics включает сбор метрик обработки команд в реестр `reg`.
+// Должен вызываться до подключения к брокеру.
+func (s *Service) EnableMetrics(reg prometheus.Registerer) {
+	s.metrics = &serviceMetrics{
+		service: s.Name,
+		requests: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ds_service_requests_total",
+			Help: "Number of processed requests by command.",
+		}, []string{"service", "cmd"})),
+		duration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ds_service_request_duration_seconds",
+			Help: "Request processing latency by command.",
+		}, []string{"service", "cmd"})),
+		inFlight: register(reg, prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ds_service_requests_in_flight",
+			Help: "Number of requests being processed.",
+		}, []string{"service"})),
+		errors: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ds_service_errors_total",
+			Help: "Number of failed requests by command and error code.",
+		}, []string{"service", "cmd", "code"})),
+	}
+}
+
+func (m *serviceMetrics) begin() {
+	if m != nil {
+		m.inFlight.WithLabelValues(m.service).Inc()
+	}
+}
+
+// end учитывает завершение обработки запроса команды `cmd` с ошибкой `err` длительностью `d`.
+func (m *serviceMetrics) end(cmd string, err error, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.inFlight.WithLabelValues(m.service).Dec()
+	m.requests.WithLabelValues(m.service, cmd).Inc()
+	m.duration.WithLabelValues(m.service, cmd).Observe(d.Seconds())
+	if err != nil {
+		m.errors.WithLabelValues(m.service, cmd, AsRPCError(err, "").Code).Inc()
+	}
+}
+
+// clientMetrics хранит метрики вызовов RPC-клиента.
+type clientMetrics struct {
+	duration *prometheus.HistogramVec
+	timeouts *prometheus.CounterVec
+}
+
+// EnableMetrics включает сбор метрик вызовов микросервисов в реестр `reg`.
+func (cl *RPCClient) EnableMetrics(reg prometheus.Registerer) {
+	m := &clientMetrics{
+		duration: register(reg, prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ds_client_call_duration_seconds",
+			Help: "RPC call latency by service.",
+		}, []string{"service"})),
+		timeouts: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ds_client_timeouts_total",
+			Help: "Number of RPC calls without answer in time by service.",
+		}, []string{"service"})),
+	}
+	cl.instrMu.Lock()
+	defer cl.instrMu.Unlock()
+	cl.metrics = m
+}
+
+func (cl *RPCClient) clientMetrics() *clientMetrics {
+	cl.instrMu.RLock()
+	defer cl.instrMu.RUnlock()
+	return cl.metrics
+}
+
+func (m *clientMetrics) observe(service string, d time.Duration) {
+	if m != nil {
+		m.duration.WithLabelValues(service).Observe(d.Seconds())
+	}
+}
+
+func (m *clientMetrics) timeout(service string) {
+	if m != nil {
+		m.timeouts.WithLabelValues(service).Inc()
+	}
+}
+
+// publisherMetrics хранит метрики публикации событий.
+type publisherMetrics struct {
+	emitted *prometheus.CounterVec
+	errors  *prometheus.CounterVec
+}
+
+// EnableMetrics включает сбор метрик публикации событий в реестр `reg`.
+// Должен вызываться до публикации событий.
+func (pub *Publisher) EnableMetrics(reg prometheus.Registerer) {
+	pub.metrics = &publisherMetrics{
+		emitted: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ds_publisher_emitted_total",
+			Help: "Number of emitted events by exchange.",
+		}, []string{"exchange"})),
+		errors: register(reg, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ds_publisher_errors_total",
+			Help: "Number of failed event emissions by exchange.",
+		}, []string{"exchange"})),
+	}
+}
+
+func (m *publisherMetrics) observe(exchange string, err error) {
+	switch {
+	case m == nil:
+	case err != nil:
+		m.errors.WithLabelValues(exchange).Inc()
+	default:
+		m.emitted.WithLabelValues(exchange).Inc()
+	}
+}
+
+// webPollerMetrics хранит метрики опроса http-ресурсов.
+type webPollerMetrics struct {
+	depth    prometheus.Gauge
+	duration prometheus.Histogram
+}
+
+// EnableMetrics включает сбор метрик опроса http-ресурсов в реестр `reg`.
+// Должен вызываться до запуска опроса.
+func (wp *WebPoller) EnableMetrics(reg prometheus.Registerer) {
+	wp.metrics = &webPollerMetrics{
+		depth: register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ds_webpoller_queue_depth",
+			Help: "Number of http requests waiting to be polled.",
+		})),
+		duration: register(reg, prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name: "ds_webpoller_request_duration_seconds",
+			Help: "Http request latency.",
+		})),
+	}
+}
+
+func (m *webPollerMetrics) queued(delta float64) {
+	if m != nil {
+		m.depth.Add(delta)
+	}
+}
+
+func (m *webPollerMetrics) observe(d time.Duration) {
+	if m != nil {
+		m.duration.Observe(d.Seconds())
+	}
+}
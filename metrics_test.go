@@ -0,0 +1,106 @@
+package microservice
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsSharedRegistry(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	NewService("first").EnableMetrics(reg)
+	NewService("second").EnableMetrics(reg)
+	assert.Panics(t, func() {
+		register(reg, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ds_service_requests_total",
+			Help: "Wrong type.",
+		}))
+	})
+}
+
+func TestComponentMetrics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reg := prometheus.NewRegistry()
+	broker := NewMemoryBroker()
+
+	srv := NewService(testServiceName)
+	srv.EnableMetrics(reg)
+	srv.SetTransport(broker)
+	require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+	go srv.Run(ctx)
+	defer srv.Cleanup()
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+	cl.EnableMetrics(reg)
+
+	_, err = Call[interface{}, PingResult](ctx, cl, testServiceName, "ping", nil)
+	require.NoError(t, err)
+	_, err = Call[interface{}, PingResult](ctx, cl, testServiceName, "x", nil)
+	require.ErrorIs(t, err, ErrUnknownCommand)
+	conn, err := broker.Dial(memoryConnStr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	_, err = ch.QueueDeclare("silent", false, false, false, false, nil)
+	require.NoError(t, err)
+	timeoutCtx, timeoutCancel := context.WithTimeout(ctx, time.Millisecond)
+	defer timeoutCancel()
+	_, err = cl.Call(timeoutCtx, "silent", []byte("{}"))
+	require.ErrorIs(t, err, ErrCallTimeout)
+
+	pub := NewPublisher("events")
+	pub.EnableMetrics(reg)
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+	require.NoError(t, pub.Emit("text/plain", []byte("event")))
+
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer web.Close()
+	poller := NewWebPoller(time.Millisecond)
+	poller.Log = log.New()
+	poller.EnableMetrics(reg)
+	poller.Start()
+	_, err = poller.Load(web.URL, nil)
+	require.NoError(t, err)
+
+	httpSrv, err := ServeMetrics("127.0.0.1:0", reg)
+	require.NoError(t, err)
+	defer httpSrv.Close()
+	resp, err := http.Get("http://" + httpSrv.Addr + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	text := string(data)
+
+	for _, line := range []string{
+		`ds_service_requests_total{cmd="ping",service="test_service"} 1`,
+		`ds_service_requests_total{cmd="_unknown",service="test_service"} 1`,
+		`ds_service_errors_total{cmd="_unknown",code="unknown_command",service="test_service"} 1`,
+		`ds_service_request_duration_seconds_count{cmd="ping",service="test_service"} 1`,
+		`ds_service_requests_in_flight{service="test_service"} 0`,
+		`ds_client_call_duration_seconds_count{service="test_service"} 2`,
+		`ds_client_timeouts_total{service="silent"} 1`,
+		`ds_publisher_emitted_total{exchange="events"} 1`,
+		`ds_webpoller_queue_depth 0`,
+		`ds_webpoller_request_duration_seconds_count 1`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+}
@@ -0,0 +1,99 @@
+// Модуль промежуточных обработчиков команд микросервиса.
+//
+// Промежуточный обработчик (Middleware) оборачивает обработчик команды и позволяет выполнить
+// общие для всех команд действия: журналирование, перехват паники, авторизацию, сбор метрик,
+// проверку параметров. Промежуточные обработчики регистрируются методом Service.Use.
+
+package microservice
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Middleware описывает промежуточный обработчик, оборачивающий обработчик `next`.
+// Промежуточный обработчик имеет доступ к запросу (в т.ч. к исходному сообщению
+// Request.Delivery) и к результату обработки команды.
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// Use добавляет промежуточные обработчики для всех команд микросервиса.
+// Первый добавленный промежуточный обработчик вызывается первым.
+func (s *Service) Use(mw ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, mw...)
+}
+
+// wrap оборачивает обработчик `h` зарегистрированными промежуточными обработчиками.
+func (s *Service) wrap(h HandlerFunc) HandlerFunc {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		h = s.middleware[i](h)
+	}
+	return h
+}
+
+// Logging возвращает промежуточный обработчик, журналирующий выполнение команд.
+// Успешно выполненные команды журналируются на уровне Debug, ошибки - на уровне Error.
+func Logging() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (interface{}, error) {
+			start := time.Now()
+			result, err := next(req)
+			entry := requestLog(req).WithField("duration", time.Since(start))
+			if err != nil {
+				entry.WithField("code", AsRPCError(err, req.Cmd).Code).Error(err)
+			} else {
+				entry.Debug("command completed")
+			}
+			return result, err
+		}
+	}
+}
+
+// Recovery возвращает промежуточный обработчик, преобразующий панику обработчика в ошибку
+// *PanicError. Стек вызовов паники журналируется.
+// Паника перехватывается микросервисом и без этого промежуточного обработчика, но
+// преобразование паники в ошибку позволяет последующим промежуточным обработчикам
+// (например, Logging) учесть ее.
+func Recovery() Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (result interface{}, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					result, err = nil, recovered(req, r)
+				}
+			}()
+			return next(req)
+		}
+	}
+}
+
+// Timing возвращает промежуточный обработчик, передающий функции `observe` длительность
+// выполнения каждой команды.
+func Timing(observe func(req *Request, d time.Duration)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(req *Request) (interface{}, error) {
+			start := time.Now()
+			defer func() {
+				observe(req, time.Since(start))
+			}()
+			return next(req)
+		}
+	}
+}
+
+// requestLog возвращает запись журнала микросервиса с реквизитами запроса.
+func requestLog(req *Request) *log.Entry {
+	logger := log.StandardLogger()
+	if req.Service != nil && req.Service.Log != nil {
+		logger = req.Service.Log
+	}
+	entry := logger.WithField("cmd", req.Cmd)
+	if req.Delivery != nil && req.Delivery.CorrelationId != "" {
+		entry = entry.WithField("correlation_id", req.Delivery.CorrelationId)
+	}
+	return entry
+}
@@ -0,0 +1,56 @@
+package microservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := startMemoryService(ctx, t, broker)
+	defer srv.Cleanup()
+
+	var mu sync.Mutex
+	var calls []string
+	var timed []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(req *Request) (interface{}, error) {
+				mu.Lock()
+				calls = append(calls, name+":"+req.Cmd)
+				mu.Unlock()
+				return next(req)
+			}
+		}
+	}
+	srv.Use(Logging(), Recovery(), Timing(func(req *Request, d time.Duration) {
+		mu.Lock()
+		defer mu.Unlock()
+		timed = append(timed, req.Cmd)
+	}))
+	srv.Use(trace("outer"), trace("inner"))
+	srv.Handle("panic", func(req *Request) (interface{}, error) {
+		panic("boom")
+	})
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Second, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+
+	_, err = Call[interface{}, PingResult](ctx, cl, testServiceName, "ping", nil)
+	require.NoError(t, err)
+	_, err = Call[interface{}, PingResult](ctx, cl, testServiceName, "panic", nil)
+	assert.ErrorIs(t, err, ErrInternal)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"outer:ping", "inner:ping", "outer:panic", "inner:panic"}, calls)
+	assert.Equal(t, []string{"ping", "panic"}, timed)
+}
@@ -0,0 +1,245 @@
+// Модуль параметров микросервиса и их задания функциональными опциями.
+
+package microservice
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Типы очередей RabbitMQ.
+const (
+	QueueClassic = "classic"
+	QueueQuorum  = "quorum"
+)
+
+// ErrInvalidConfig возвращается для некорректных параметров.
+var ErrInvalidConfig = errors.New("invalid configuration")
+
+// QueueConfig описывает параметры объявления очереди.
+type QueueConfig struct {
+	// очередь сохраняется при перезапуске брокера
+	Durable bool `json:"durable" yaml:"durable" toml:"durable"`
+	// очередь удаляется после отключения последнего потребителя
+	AutoDelete bool `json:"auto_delete" yaml:"auto_delete" toml:"auto_delete"`
+	// очередь доступна только текущему подключению
+	Exclusive bool `json:"exclusive" yaml:"exclusive" toml:"exclusive"`
+	// тип очереди (QueueClassic, QueueQuorum)
+	Type string `json:"type" yaml:"type" toml:"type"`
+	// точка обмена для отклоненных и просроченных сообщений
+	DeadLetterExchange string `json:"dead_letter_exchange" yaml:"dead_letter_exchange" toml:"dead_letter_exchange"`
+	// ключ маршрутизации для отклоненных сообщений
+	DeadLetterRoutingKey string `json:"dead_letter_routing_key" yaml:"dead_letter_routing_key" toml:"dead_letter_routing_key"`
+	// время жизни сообщения в очереди
+	MessageTTL time.Duration `json:"message_ttl" yaml:"message_ttl" toml:"message_ttl"`
+	// максимальное число сообщений в очереди
+	MaxLength int `json:"max_length" yaml:"max_length" toml:"max_length"`
+	// дополнительные аргументы объявления очереди
+	Args amqp.Table `json:"args" yaml:"args" toml:"args"`
+}
+
+// Validate проверяет согласованность параметров очереди.
+func (cfg QueueConfig) Validate() error {
+	switch cfg.Type {
+	case "", QueueClassic:
+	case QueueQuorum:
+		if !cfg.Durable || cfg.AutoDelete || cfg.Exclusive {
+			return fmt.Errorf(
+				"%w: quorum queue must be durable, not auto-deleted and not exclusive",
+				ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: unknown queue type '%s'", ErrInvalidConfig, cfg.Type)
+	}
+	if cfg.MessageTTL < 0 {
+		return fmt.Errorf("%w: negative message TTL", ErrInvalidConfig)
+	}
+	if cfg.MaxLength < 0 {
+		return fmt.Errorf("%w: negative queue max length", ErrInvalidConfig)
+	}
+	if cfg.DeadLetterRoutingKey != "" && cfg.DeadLetterExchange == "" {
+		return fmt.Errorf("%w: dead-letter routing key without exchange", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// Table формирует аргументы объявления очереди.
+func (cfg QueueConfig) Table() amqp.Table {
+	args := amqp.Table{}
+	for k, v := range cfg.Args {
+		args[k] = v
+	}
+	if cfg.Type != "" {
+		args["x-queue-type"] = cfg.Type
+	}
+	if cfg.DeadLetterExchange != "" {
+		args["x-dead-letter-exchange"] = cfg.DeadLetterExchange
+	}
+	if cfg.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = cfg.DeadLetterRoutingKey
+	}
+	if cfg.MessageTTL > 0 {
+		args["x-message-ttl"] = int64(cfg.MessageTTL / time.Millisecond)
+	}
+	if cfg.MaxLength > 0 {
+		args["x-max-length"] = int64(cfg.MaxLength)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// ServiceConfig описывает параметры микросервиса.
+type ServiceConfig struct {
+	// параметры очереди запросов
+	Queue QueueConfig `json:"queue" yaml:"queue" toml:"queue"`
+	// метка потребителя (по умолчанию формируется из имени сервиса)
+	ConsumerTag string `json:"consumer_tag" yaml:"consumer_tag" toml:"consumer_tag"`
+	// ограничения параллельной обработки запросов
+	Concurrency Concurrency `json:"concurrency" yaml:"concurrency" toml:"concurrency"`
+	// политика обработки запросов, вызвавших панику (PanicAck, PanicDeadLetter)
+	PanicPolicy string `json:"panic_policy" yaml:"panic_policy" toml:"panic_policy"`
+	// адрес встроенного http-сервера проверки работоспособности (см. health.go)
+	HealthAddr string `json:"health_addr" yaml:"health_addr" toml:"health_addr"`
+	// срок ожидания подтверждения брокером публикации ответов (0 - без подтверждений)
+	ConfirmTimeout time.Duration `json:"confirm_timeout" yaml:"confirm_timeout" toml:"confirm_timeout"`
+}
+
+// DefaultServiceConfig возвращает умолчательные параметры микросервиса.
+func DefaultServiceConfig() ServiceConfig {
+	return ServiceConfig{Concurrency: DefaultConcurrency}
+}
+
+// Validate проверяет корректность параметров микросервиса.
+func (cfg ServiceConfig) Validate() error {
+	c := cfg.Concurrency
+	if c.MaxInFlight < 0 || c.Prefetch < 0 {
+		return fmt.Errorf("%w: negative concurrency limit", ErrInvalidConfig)
+	}
+	for cmd, limit := range c.CommandLimits {
+		if limit < 0 {
+			return fmt.Errorf("%w: negative limit for command '%s'", ErrInvalidConfig, cmd)
+		}
+	}
+	switch cfg.PanicPolicy {
+	case "", PanicAck, PanicDeadLetter:
+	default:
+		return fmt.Errorf("%w: unknown panic policy '%s'", ErrInvalidConfig, cfg.PanicPolicy)
+	}
+	if cfg.ConfirmTimeout < 0 {
+		return fmt.Errorf("%w: negative confirm timeout", ErrInvalidConfig)
+	}
+	return cfg.Queue.Validate()
+}
+
+// ServiceOption задает параметр микросервиса при его создании.
+type ServiceOption func(cfg *ServiceConfig)
+
+// WithConfig заменяет все параметры микросервиса на `c`.
+func WithConfig(c ServiceConfig) ServiceOption {
+	return func(cfg *ServiceConfig) { *cfg = c }
+}
+
+// WithDurableQueue объявляет очередь запросов, сохраняемую при перезапуске брокера.
+func WithDurableQueue() ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Queue.Durable = true }
+}
+
+// WithAutoDelete объявляет очередь запросов, удаляемую после отключения сервиса.
+func WithAutoDelete() ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Queue.AutoDelete = true }
+}
+
+// WithExclusiveQueue объявляет очередь запросов, доступную только подключению сервиса.
+func WithExclusiveQueue() ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Queue.Exclusive = true }
+}
+
+// WithQueueType задает тип очереди запросов (QueueClassic, QueueQuorum).
+// Кворумная очередь объявляется сохраняемой.
+func WithQueueType(queueType string) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Queue.Type = queueType
+		if queueType == QueueQuorum {
+			cfg.Queue.Durable = true
+		}
+	}
+}
+
+// WithDeadLetter задает точку обмена и ключ маршрутизации для отклоненных запросов.
+func WithDeadLetter(exchange, routingKey string) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		cfg.Queue.DeadLetterExchange = exchange
+		cfg.Queue.DeadLetterRoutingKey = routingKey
+	}
+}
+
+// WithMessageTTL задает время жизни запроса в очереди.
+func WithMessageTTL(ttl time.Duration) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Queue.MessageTTL = ttl }
+}
+
+// WithMaxLength задает максимальное число запросов в очереди.
+func WithMaxLength(n int) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Queue.MaxLength = n }
+}
+
+// WithQueueArgs задает дополнительные аргументы объявления очереди запросов.
+func WithQueueArgs(args amqp.Table) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Queue.Args = args }
+}
+
+// WithConsumerTag задает метку потребителя очереди запросов.
+func WithConsumerTag(tag string) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.ConsumerTag = tag }
+}
+
+// WithConcurrency задает ограничения параллельной обработки запросов.
+func WithConcurrency(c Concurrency) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Concurrency = c }
+}
+
+// WithPrefetch задает число неквитированных запросов, получаемых от брокера.
+func WithPrefetch(n int) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Concurrency.Prefetch = n }
+}
+
+// WithMaxInFlight задает число одновременно выполняемых обработчиков.
+func WithMaxInFlight(n int) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.Concurrency.MaxInFlight = n }
+}
+
+// WithCommandLimit задает число одновременно выполняемых обработчиков команды `cmd`.
+func WithCommandLimit(cmd string, n int) ServiceOption {
+	return func(cfg *ServiceConfig) {
+		limits := map[string]int{}
+		for k, v := range cfg.Concurrency.CommandLimits {
+			limits[k] = v
+		}
+		limits[cmd] = n
+		cfg.Concurrency.CommandLimits = limits
+	}
+}
+
+// WithPanicPolicy задает политику обработки запросов, вызвавших панику обработчика
+// (PanicAck, PanicDeadLetter). Отклоненные запросы перенаправляются в точку обмена,
+// заданную WithDeadLetter.
+func WithPanicPolicy(policy string) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.PanicPolicy = policy }
+}
+
+// WithHealthServer запускает при подключении к брокеру встроенный http-сервер проверки
+// работоспособности на адресе `addr` (например, ":8080").
+func WithHealthServer(addr string) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.HealthAddr = addr }
+}
+
+// WithPublisherConfirms включает подтверждение брокером публикации ответов со сроком
+// ожидания `timeout` (см. confirm.go).
+func WithPublisherConfirms(timeout time.Duration) ServiceOption {
+	return func(cfg *ServiceConfig) { cfg.ConfirmTimeout = timeout }
+}
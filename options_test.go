@@ -0,0 +1,51 @@
+package microservice
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceOptions(t *testing.T) {
+	srv := NewService(testServiceName,
+		WithQueueType(QueueQuorum),
+		WithDeadLetter("dlx", "failed"),
+		WithMessageTTL(time.Minute),
+		WithMaxLength(1000),
+		WithPrefetch(16),
+		WithCommandLimit("slow", 2))
+	cfg := srv.Config()
+	require.NoError(t, cfg.Validate())
+	assert.True(t, cfg.Queue.Durable)
+	assert.Equal(t, 16, cfg.Concurrency.Prefetch)
+	assert.Equal(t, map[string]int{"slow": 2}, cfg.Concurrency.CommandLimits)
+	assert.Equal(t, amqp.Table{
+		"x-queue-type":              QueueQuorum,
+		"x-dead-letter-exchange":    "dlx",
+		"x-dead-letter-routing-key": "failed",
+		"x-message-ttl":             int64(60000),
+		"x-max-length":              int64(1000),
+	}, cfg.Queue.Table())
+	assert.Nil(t, DefaultServiceConfig().Queue.Table())
+
+	// значения, превышающие диапазон int32, передаются без переполнения
+	long := QueueConfig{MessageTTL: 30 * 24 * time.Hour}.Table()
+	assert.Equal(t, int64(2592000000), long["x-message-ttl"])
+}
+
+func TestServiceConfigValidation(t *testing.T) {
+	for _, opts := range [][]ServiceOption{
+		{WithQueueType(QueueQuorum), WithExclusiveQueue()},
+		{WithQueueType("stream")},
+		{WithMaxLength(-1)},
+		{WithDeadLetter("", "failed")},
+		{WithCommandLimit("slow", -1)},
+		{WithPanicPolicy("retry")},
+	} {
+		err := NewService(testServiceName, opts...).ConnectToMessageBrokerE(DefaultRabbitMQConnStr)
+		assert.ErrorIs(t, err, ErrInvalidConfig)
+	}
+}
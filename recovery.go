@@ -0,0 +1,72 @@
+// Модуль перехвата паники в обработчиках команд микросервиса.
+//
+// Паника обработчика не завершает работу микросервиса: клиенту возвращается ошибка с кодом
+// CodeInternal и идентификатором паники, стек вызовов журналируется вместе с идентификатором
+// запроса (CorrelationId), а запрос квитируется или перенаправляется в точку обмена
+// отклоненных запросов в соответствии с ServiceConfig.PanicPolicy.
+
+package microservice
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+
+	"github.com/gofrs/uuid"
+	"github.com/streadway/amqp"
+)
+
+// Политики обработки запросов, вызвавших панику обработчика.
+const (
+	PanicAck        = "ack"         // запрос подтверждается (по умолчанию)
+	PanicDeadLetter = "dead-letter" // запрос отклоняется без возврата в очередь
+)
+
+// PanicError описывает панику обработчика команды.
+type PanicError struct {
+	ID    string      // идентификатор паники для поиска в журнале
+	Value interface{} // значение, переданное в panic
+	Stack []byte      // стек вызовов в момент паники
+}
+
+// PanicDetails описывает дополнительные сведения ошибки, возвращаемой клиенту при панике
+// обработчика (см. RPCError.DecodeDetails).
+type PanicDetails struct {
+	PanicID string `json:"panic_id"`
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic %s: %v", e.ID, e.Value)
+}
+
+// RPCError возвращает ошибку для передачи клиенту.
+// Значение паники и стек вызовов клиенту не передаются.
+func (e *PanicError) RPCError() *RPCError {
+	return Errorf(CodeInternal, "Internal error (panic ID %s)", e.ID).
+		WithDetails(PanicDetails{PanicID: e.ID})
+}
+
+// recovered формирует ошибку по значению паники `r` обработчика запроса `req` и журналирует
+// стек вызовов.
+func recovered(req *Request, r interface{}) *PanicError {
+	id, _ := uuid.NewV4()
+	e := &PanicError{ID: id.String(), Value: r, Stack: debug.Stack()}
+	requestLog(req).WithField("panic_id", e.ID).Errorf("%v\n%s", e, e.Stack)
+	return e
+}
+
+// answerPanic отправляет клиенту ответ с ошибкой `e` и завершает обработку запроса
+// в соответствии с политикой PanicPolicy.
+func (s *Service) answerPanic(delivery *amqp.Delivery, e *PanicError, context string) error {
+	if delivery.ReplyTo != "" {
+		data, err := json.Marshal(NewErrorEnvelope(e.RPCError(), context))
+		if err == nil {
+			err = s.reply(delivery, data)
+		}
+		s.LogOnErrorWithContext(err, "Answer")
+	}
+	if s.cfg.PanicPolicy == PanicDeadLetter {
+		return s.settle(delivery, func() error { return delivery.Nack(false, false) })
+	}
+	return s.settle(delivery, func() error { return delivery.Ack(false) })
+}
@@ -0,0 +1,71 @@
+package microservice
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPanicRecovery(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		acked    int
+		rejected int
+	}{
+		{PanicAck, 1, 0},
+		{PanicDeadLetter, 0, 1},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			broker := NewMemoryBroker()
+			conn, err := broker.Dial(memoryConnStr)
+			require.NoError(t, err)
+			defer conn.Close()
+			ch, err := conn.Channel()
+			require.NoError(t, err)
+			require.NoError(t, ch.ExchangeDeclare("dlx", amqp.ExchangeFanout, false, false, false, false, nil))
+			_, err = ch.QueueDeclare("dead", false, false, false, false, nil)
+			require.NoError(t, err)
+			require.NoError(t, ch.QueueBind("dead", "", "dlx", false, nil))
+
+			srv := NewService(testServiceName, WithDeadLetter("dlx", ""), WithPanicPolicy(tc.policy))
+			srv.SetTransport(broker)
+			require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+			go srv.Run(ctx)
+			defer srv.Cleanup()
+			srv.Handle("panic", func(req *Request) (interface{}, error) {
+				panic("boom")
+			})
+
+			cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Second, Transport: broker})
+			require.NoError(t, err)
+			defer cl.Close()
+
+			_, err = Call[interface{}, PingResult](ctx, cl, testServiceName, "panic", nil)
+			require.ErrorIs(t, err, ErrInternal)
+			var rpcErr *RPCError
+			require.True(t, errors.As(err, &rpcErr))
+			var details PanicDetails
+			require.NoError(t, rpcErr.DecodeDetails(&details))
+			assert.NotEmpty(t, details.PanicID)
+			assert.Contains(t, rpcErr.Message, details.PanicID)
+			assert.NotContains(t, rpcErr.Message, "boom")
+
+			assert.Eventually(t, func() bool {
+				st := broker.Stats(testServiceName)
+				return st.Acked == tc.acked && st.Rejected == tc.rejected
+			}, time.Second, time.Millisecond)
+			assert.Equal(t, tc.rejected, broker.QueueLength("dead"))
+
+			ping, err := Call[interface{}, PingResult](ctx, cl, testServiceName, "ping", nil)
+			require.NoError(t, err)
+			assert.Equal(t, testServiceName, ping.Service)
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package microservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRPCClientReplyRouting(t *testing.T) {
+	cl := &RPCClient{msgs: make(chan amqp.Delivery), pending: map[string]chan rpcReply{}}
+	cl.init()
+	go cl.routeReplies()
+	defer cl.Close()
+
+	ids := []string{"a", "b", "c"}
+	for _, id := range ids {
+		cl.waiter(id)
+	}
+	var wg sync.WaitGroup
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			assert.Equal(t, id, string(cl.Result(id)))
+		}(id)
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		cl.msgs <- amqp.Delivery{CorrelationId: ids[i], Body: []byte(ids[i])}
+	}
+	wg.Wait()
+	assert.Empty(t, cl.pending)
+}
+
+func TestRPCClientWaitTimeout(t *testing.T) {
+	cl := &RPCClient{msgs: make(chan amqp.Delivery), pending: map[string]chan rpcReply{}}
+	cl.init()
+	go cl.routeReplies()
+	defer cl.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := cl.wait(ctx, testServiceName, "late")
+	assert.ErrorIs(t, err, ErrCallTimeout)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Empty(t, cl.pending)
+
+	cl.msgs <- amqp.Delivery{CorrelationId: "late"}
+	assert.Empty(t, cl.pending)
+}
+
+func TestRPCClientResultExpires(t *testing.T) {
+	broker := NewMemoryBroker()
+	conn, err := broker.Dial(memoryConnStr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	_, err = ch.QueueDeclare("silent", false, false, false, false, nil)
+	require.NoError(t, err)
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{
+		URL:       memoryConnStr,
+		Timeout:   10 * time.Millisecond,
+		Transport: broker,
+	})
+	require.NoError(t, err)
+	defer cl.Close()
+
+	// ответ, за которым не обратились, не ожидается бесконечно
+	require.NoError(t, cl.RequestE("silent", "forgotten", []byte("{}")))
+	assert.Eventually(t, func() bool {
+		cl.pendingMu.Lock()
+		defer cl.pendingMu.Unlock()
+		return len(cl.pending) == 0 && len(cl.deadlines) == 0
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, cl.RequestE("silent", "late", []byte("{}")))
+	assert.Nil(t, cl.Result("late"))
+	cl.pendingMu.Lock()
+	defer cl.pendingMu.Unlock()
+	assert.Empty(t, cl.pending)
+}
+
+func TestRPCClientServiceUnavailable(t *testing.T) {
+	broker := NewMemoryBroker()
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Minute, Transport: broker})
+	assert.NoError(t, err)
+	defer cl.Close()
+
+	start := time.Now()
+	_, err = Call[interface{}, PingResult](context.Background(), cl, "missing", "ping", nil)
+	assert.ErrorIs(t, err, ErrServiceUnavailable)
+	assert.ErrorIs(t, err, ErrUnavailable)
+	assert.Contains(t, err.Error(), "'missing'")
+	assert.Less(t, time.Since(start), time.Second)
+	assert.Empty(t, cl.pending)
+}
+
+func TestRPCClientReconnect(t *testing.T) {
+	broker := NewMemoryBroker()
+	conn, err := broker.Dial(memoryConnStr)
+	require.NoError(t, err)
+	defer conn.Close()
+	ch, err := conn.Channel()
+	require.NoError(t, err)
+	_, err = ch.QueueDeclare("silent", false, false, false, false, nil)
+	require.NoError(t, err)
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Minute, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+	cl.SetBackoff(Backoff{Initial: time.Millisecond})
+	queue := cl.replyQueue()
+
+	const calls = 3
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		go func() {
+			_, err := Call[interface{}, PingResult](context.Background(), cl, "silent", "ping", nil)
+			errs <- err
+		}()
+	}
+	require.Eventually(t, func() bool { return broker.QueueLength("silent") == calls },
+		time.Second, time.Millisecond)
+
+	broker.Disconnect("test")
+	for i := 0; i < calls; i++ {
+		select {
+		case err := <-errs:
+			assert.ErrorIs(t, err, ErrNotConnected)
+		case <-time.After(time.Second):
+			t.Fatal("pending call is not failed on reconnect")
+		}
+	}
+	assert.NotEqual(t, queue, cl.replyQueue())
+}
+
+func TestRPCClientCallError(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := NewService(testServiceName)
+	srv.SetTransport(broker)
+	require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+	go srv.Run(ctx)
+	defer srv.Cleanup()
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Second, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+
+	_, req, err := CreateCmdRequest("x")
+	require.NoError(t, err)
+	data, err := cl.Call(ctx, testServiceName, req)
+	assert.ErrorIs(t, err, ErrUnknownCommand)
+	assert.NotErrorIs(t, err, ErrCallTimeout)
+	env, perr := ParseEnvelope(data)
+	require.NoError(t, perr)
+	assert.Equal(t, env.Err(), err)
+
+	_, req, err = CreateCmdRequest("ping")
+	require.NoError(t, err)
+	data, err = cl.Call(ctx, testServiceName, req)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, data)
+}
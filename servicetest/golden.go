@@ -0,0 +1,47 @@
+package servicetest
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// EnvUpdateGolden - переменная окружения, непустое значение которой (кроме "0" и "false")
+// включает перезапись эталонных файлов данными тестов.
+const EnvUpdateGolden = "DS_UPDATE_GOLDEN"
+
+// updateGolden сообщает, требуется ли перезаписать эталонные файлы.
+func updateGolden() bool {
+	v := os.Getenv(EnvUpdateGolden)
+	return v != "" && v != "0" && v != "false"
+}
+
+// GoldenPath возвращает путь к эталонному файлу с именем `name`.
+func GoldenPath(name string) string {
+	return filepath.Join("testdata", name+".golden.json")
+}
+
+// Golden сравнивает JSON-данные `got` с эталоном testdata/<name>.golden.json без учета
+// форматирования и порядка полей. При запуске тестов с переменной окружения
+// DS_UPDATE_GOLDEN=1 эталон перезаписывается данными `got`.
+func Golden(t testing.TB, name string, got []byte) {
+	t.Helper()
+	var formatted bytes.Buffer
+	require.NoError(t, json.Indent(&formatted, got, "", "  "), "invalid JSON: %s", got)
+	formatted.WriteByte('\n')
+
+	path := GoldenPath(name)
+	if updateGolden() {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, formatted.Bytes(), 0644))
+		return
+	}
+	want, err := os.ReadFile(path)
+	require.NoError(t, err, "run tests with %s=1 to create golden file", EnvUpdateGolden)
+	assert.JSONEq(t, string(want), formatted.String(), "golden file %s", path)
+}
@@ -0,0 +1,283 @@
+// Package servicetest содержит средства тестирования обработчиков микросервисов.
+//
+// Harness запускает микросервис на брокере сообщений внутри процесса (MemoryBroker) и
+// предоставляет подключенный к нему RPC-клиент:
+//
+//	srv := microservice.NewService("echo")
+//	srv.Handle("echo", echoHandler)
+//	h := servicetest.Start(t, srv)
+//	h.Call("echo", params).AssertOK().AssertGolden("echo")
+//	h.AssertAcked(1)
+//
+// Эталонные JSON-ответы хранятся в каталоге testdata и обновляются при запуске тестов
+// с переменной окружения DS_UPDATE_GOLDEN=1.
+package servicetest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	microservice "github.com/ytsiuryn/ds-microservice"
+)
+
+// ConnStr - адрес брокера, используемый компонентами теста.
+const ConnStr = "memory://"
+
+// DefaultTimeout - умолчательный срок ожидания ответов и событий.
+const DefaultTimeout = time.Second
+
+// Harness хранит состояние запущенного для теста микросервиса.
+type Harness struct {
+	T       testing.TB
+	Broker  *microservice.MemoryBroker
+	Service *microservice.Service
+	Client  *microservice.RPCClient
+	Timeout time.Duration // срок ожидания ответов и событий
+}
+
+// Start подключает микросервис `srv` к новому брокеру внутри процесса, запускает обработку
+// запросов и создает RPC-клиент. Ресурсы освобождаются по завершении теста.
+func Start(t testing.TB, srv *microservice.Service) *Harness {
+	t.Helper()
+	h := &Harness{
+		T:       t,
+		Broker:  microservice.NewMemoryBroker(),
+		Service: srv,
+		Timeout: DefaultTimeout,
+	}
+	srv.SetTransport(h.Broker)
+	require.NoError(t, srv.ConnectToMessageBrokerE(ConnStr))
+	ctx, cancel := context.WithCancel(context.Background())
+	go srv.Run(ctx)
+
+	cl, err := microservice.NewRPCClientFromConfig(microservice.ClientConfig{
+		URL:       ConnStr,
+		Timeout:   h.Timeout,
+		Transport: h.Broker,
+	})
+	require.NoError(t, err)
+	h.Client = cl
+
+	t.Cleanup(func() {
+		cl.Close()
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), h.Timeout)
+		defer shutdownCancel()
+		srv.Shutdown(shutdownCtx)
+		cancel()
+	})
+	return h
+}
+
+// Call выполняет команду `cmd` микросервиса с параметрами `params` и возвращает ответ.
+func (h *Harness) Call(cmd string, params interface{}) *Reply {
+	h.T.Helper()
+	env, err := microservice.NewRequestEnvelope(cmd, params)
+	require.NoError(h.T, err)
+	data, err := json.Marshal(env)
+	require.NoError(h.T, err)
+	return h.Send(data)
+}
+
+// Send отправляет микросервису запрос `body` в произвольном виде и возвращает ответ.
+func (h *Harness) Send(body []byte) *Reply {
+	h.T.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), h.Timeout)
+	defer cancel()
+	data, err := h.Client.Call(ctx, h.Service.Name, body)
+	if len(data) == 0 {
+		require.NoError(h.T, err)
+	}
+	env, err := microservice.ParseEnvelope(data)
+	require.NoError(h.T, err, "malformed reply: %s", data)
+	return &Reply{t: h.T, Body: data, Envelope: env}
+}
+
+// Stats возвращает счетчики сообщений очереди запросов микросервиса.
+func (h *Harness) Stats() microservice.QueueStats {
+	return h.Broker.Stats(h.Service.Name)
+}
+
+// AssertAcked проверяет, что микросервис подтвердил `n` запросов.
+func (h *Harness) AssertAcked(n int) {
+	h.T.Helper()
+	h.eventually(n, "acked", func(st microservice.QueueStats) int { return st.Acked })
+}
+
+// AssertRequeued проверяет, что микросервис вернул в очередь `n` запросов.
+func (h *Harness) AssertRequeued(n int) {
+	h.T.Helper()
+	h.eventually(n, "requeued", func(st microservice.QueueStats) int { return st.Requeued })
+}
+
+// AssertRejected проверяет, что микросервис отклонил без возврата в очередь `n` запросов.
+func (h *Harness) AssertRejected(n int) {
+	h.T.Helper()
+	h.eventually(n, "rejected", func(st microservice.QueueStats) int { return st.Rejected })
+}
+
+// eventually дожидается значения `n` счетчика очереди запросов. Квитирование выполняется
+// после отправки ответа, поэтому счетчик проверяется до истечения срока ожидания.
+func (h *Harness) eventually(n int, name string, counter func(microservice.QueueStats) int) {
+	h.T.Helper()
+	deadline := time.Now().Add(h.Timeout)
+	for {
+		got := counter(h.Stats())
+		if got == n || time.Now().After(deadline) {
+			assert.Equal(h.T, n, got, "number of %s requests", name)
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// NewPublisher создает издателя, подключенного к брокеру теста.
+func (h *Harness) NewPublisher(exchange string, opts ...microservice.ExchangeOption) *microservice.Publisher {
+	h.T.Helper()
+	pub := microservice.NewPublisher(exchange, opts...)
+	pub.SetTransport(h.Broker)
+	require.NoError(h.T, pub.ConnectE(ConnStr))
+	h.T.Cleanup(pub.Close)
+	return pub
+}
+
+// Subscribe начинает запись событий, публикуемых в точку обмена `exchange`.
+// Параметры `opts` задают тип точки обмена и привязку очереди подписчика.
+// Запись выполняется до завершения теста.
+func (h *Harness) Subscribe(exchange string, opts ...microservice.ExchangeOption) *Recorder {
+	h.T.Helper()
+	sub := microservice.NewSubscriber(exchange, opts...)
+	sub.SetTransport(h.Broker)
+	require.NoError(h.T, sub.ConnectE(ConnStr))
+	rec := &Recorder{t: h.T, timeout: h.Timeout, signal: make(chan struct{}, 1)}
+	deliveries := make(chan amqp.Delivery)
+	go func() {
+		for d := range deliveries {
+			rec.add(d)
+		}
+	}()
+	go func() {
+		sub.ReceiveE(deliveries)
+		close(deliveries)
+	}()
+	h.T.Cleanup(sub.Close)
+	return rec
+}
+
+// Reply описывает ответ микросервиса.
+type Reply struct {
+	t        testing.TB
+	Body     []byte
+	Envelope *microservice.Envelope
+}
+
+// AssertOK проверяет, что ответ не содержит ошибки.
+func (r *Reply) AssertOK() *Reply {
+	r.t.Helper()
+	assert.NoError(r.t, r.Envelope.Err())
+	return r
+}
+
+// AssertResult проверяет, что результат ответа совпадает с JSON-представлением `want`.
+func (r *Reply) AssertResult(want interface{}) *Reply {
+	r.t.Helper()
+	if !assert.NoError(r.t, r.Envelope.Err()) {
+		return r
+	}
+	expected, err := json.Marshal(want)
+	require.NoError(r.t, err)
+	assert.JSONEq(r.t, string(expected), string(r.Envelope.Result))
+	return r
+}
+
+// AssertErrorCode проверяет, что ответ содержит ошибку с кодом `code`.
+func (r *Reply) AssertErrorCode(code string) *Reply {
+	r.t.Helper()
+	if assert.NotNil(r.t, r.Envelope.Error, "reply has no error: %s", r.Body) {
+		assert.Equal(r.t, code, r.Envelope.Error.Code)
+	}
+	return r
+}
+
+// AssertError проверяет, что ошибка ответа соответствует `target` (см. errors.Is).
+func (r *Reply) AssertError(target error) *Reply {
+	r.t.Helper()
+	assert.True(r.t, errors.Is(r.Envelope.Err(), target),
+		"reply error %v does not match %v", r.Envelope.Err(), target)
+	return r
+}
+
+// Decode декодирует результат ответа в `v`.
+func (r *Reply) Decode(v interface{}) {
+	r.t.Helper()
+	require.NoError(r.t, r.Envelope.Decode(v))
+}
+
+// AssertGolden сравнивает ответ с эталоном testdata/<name>.golden.json (см. Golden).
+func (r *Reply) AssertGolden(name string) *Reply {
+	r.t.Helper()
+	Golden(r.t, name, r.Body)
+	return r
+}
+
+// Recorder хранит события, полученные подписчиком.
+type Recorder struct {
+	t       testing.TB
+	timeout time.Duration
+	mu      sync.Mutex
+	events  []amqp.Delivery
+	signal  chan struct{}
+}
+
+func (rec *Recorder) add(d amqp.Delivery) {
+	rec.mu.Lock()
+	rec.events = append(rec.events, d)
+	rec.mu.Unlock()
+	select {
+	case rec.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Events возвращает полученные события.
+func (rec *Recorder) Events() []amqp.Delivery {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	return append([]amqp.Delivery(nil), rec.events...)
+}
+
+// Wait дожидается получения не менее `n` событий и возвращает их.
+func (rec *Recorder) Wait(n int) []amqp.Delivery {
+	rec.t.Helper()
+	timer := time.NewTimer(rec.timeout)
+	defer timer.Stop()
+	for {
+		if events := rec.Events(); len(events) >= n {
+			return events
+		}
+		select {
+		case <-rec.signal:
+		case <-timer.C:
+			events := rec.Events()
+			require.Failf(rec.t, "not enough events", "want %d, got %d", n, len(events))
+			return events
+		}
+	}
+}
+
+// AssertEventJSON проверяет, что событие с номером `i` (начиная с 0) содержит
+// JSON-представление `want`.
+func (rec *Recorder) AssertEventJSON(i int, want interface{}) {
+	rec.t.Helper()
+	events := rec.Wait(i + 1)
+	expected, err := json.Marshal(want)
+	require.NoError(rec.t, err)
+	assert.JSONEq(rec.t, string(expected), string(events[i].Body))
+}
@@ -0,0 +1,64 @@
+package servicetest
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	microservice "github.com/ytsiuryn/ds-microservice"
+)
+
+type greetParams struct {
+	Name string `json:"name"`
+}
+
+type greetResult struct {
+	Greeting string `json:"greeting"`
+}
+
+func newGreeter(t *testing.T) *Harness {
+	srv := microservice.NewService("greeter")
+	h := Start(t, srv)
+	pub := h.NewPublisher("greetings")
+	microservice.HandleTyped(srv, "greet", func(req *microservice.Request, p greetParams) (greetResult, error) {
+		if p.Name == "" {
+			return greetResult{}, microservice.Errorf(microservice.CodeInvalidParams, "empty name")
+		}
+		res := greetResult{Greeting: "Hello, " + p.Name}
+		return res, pub.Emit("application/json", []byte(`{"name":"`+p.Name+`"}`))
+	})
+	return h
+}
+
+func TestHarnessReplies(t *testing.T) {
+	h := newGreeter(t)
+
+	h.Call("greet", greetParams{Name: "Bob"}).
+		AssertOK().
+		AssertResult(greetResult{Greeting: "Hello, Bob"}).
+		AssertGolden("greet")
+
+	h.Call("greet", greetParams{}).
+		AssertErrorCode(microservice.CodeInvalidParams).
+		AssertError(microservice.ErrInvalidParams)
+	h.Call("x", nil).AssertError(microservice.ErrUnknownCommand)
+
+	var ping microservice.PingResult
+	h.Call("ping", nil).Decode(&ping)
+	assert.Equal(t, "greeter", ping.Service)
+
+	h.AssertAcked(4)
+	h.AssertRejected(0)
+}
+
+func TestHarnessEvents(t *testing.T) {
+	h := newGreeter(t)
+	events := h.Subscribe("greetings")
+
+	h.Call("greet", greetParams{Name: "Alice"}).AssertOK()
+	h.Call("greet", greetParams{Name: "Bob"}).AssertOK()
+
+	assert.Len(t, events.Wait(2), 2)
+	events.AssertEventJSON(0, greetParams{Name: "Alice"})
+	events.AssertEventJSON(1, greetParams{Name: "Bob"})
+}
@@ -0,0 +1,161 @@
+// Модуль штатной остановки микросервиса.
+//
+// При остановке сервис прекращает получение новых запросов и дожидается завершения
+// обрабатываемых. Запросы, не обработанные до истечения срока остановки, возвращаются
+// в очередь брокера.
+
+package microservice
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// Shutdown останавливает сервис: отменяет потребителя очереди запросов, дожидается
+// завершения обрабатываемых запросов до истечения срока контекста `ctx`, возвращает
+// в очередь незавершенные запросы и освобождает ресурсы брокера.
+// Если не все запросы были обработаны, возвращается ошибка контекста.
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.stopOnce.Do(func() {
+		s.activeMu.Lock()
+		close(s.stopping)
+		s.activeMu.Unlock()
+	})
+
+	if s.consumerTag != "" {
+		err := s.withChannel(func(ch Channel) error {
+			return ch.Cancel(s.consumerTag, false)
+		})
+		if err != nil {
+			s.LogOnErrorWithContext(err, "Consumer cancellation")
+		}
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		s.mu.RLock()
+		if s.abort != nil {
+			s.abort()
+		}
+		s.mu.RUnlock()
+		if n := s.requeueActive(); n > 0 {
+			err = fmt.Errorf("%d unfinished requests requeued: %w", n, ctx.Err())
+		}
+	}
+
+	s.Cleanup()
+	return err
+}
+
+// ShutdownOnSignal запускает ожидание сигналов `sigs` (по умолчанию SIGINT и SIGTERM),
+// при получении которого сервис останавливается методом Shutdown со сроком `timeout`.
+func (s *Service) ShutdownOnSignal(timeout time.Duration, sigs ...os.Signal) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		select {
+		case sig := <-c:
+			s.Log.Infof("%s received, shutting down", sig)
+		case <-s.stopping:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		s.LogOnErrorWithContext(s.Shutdown(ctx), "Shutdown")
+	}()
+}
+
+func (s *Service) isStopping() bool {
+	select {
+	case <-s.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliveryState описывает состояние обрабатываемого запроса.
+type deliveryState int
+
+// Состояния обрабатываемого запроса.
+const (
+	deliveryActive   deliveryState = iota // запрос обрабатывается
+	deliverySettled                       // запрос квитирован обработчиком
+	deliveryRequeued                      // запрос возвращен в очередь при остановке сервиса
+)
+
+// track регистрирует полученный запрос. После начала остановки запросы не регистрируются
+// (возвращается false), поэтому ожидание их завершения в Shutdown не пропускает
+// ни одного запроса.
+func (s *Service) track(delivery *amqp.Delivery) bool {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	if s.isStopping() {
+		return false
+	}
+	s.inflight.Add(1)
+	s.active[delivery] = deliveryActive
+	return true
+}
+
+// untrack снимает регистрацию обработанного запроса. Возвращает false, если запрос уже
+// был квитирован или возвращен в очередь при остановке сервиса.
+func (s *Service) untrack(delivery *amqp.Delivery) bool {
+	s.activeMu.Lock()
+	state, ok := s.active[delivery]
+	delete(s.active, delivery)
+	s.activeMu.Unlock()
+	s.inflight.Done()
+	return ok && state == deliveryActive
+}
+
+// requeueActive возвращает в очередь брокера незавершенные и еще не квитированные запросы
+// и возвращает их число. Запросы помечаются как возвращенные, чтобы их обработчики,
+// завершившиеся позже, не квитировали запрос повторно (см. settle).
+func (s *Service) requeueActive() int {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	n := 0
+	for delivery, state := range s.active {
+		if state != deliveryActive {
+			continue
+		}
+		s.LogOnError(delivery.Nack(false, true))
+		s.active[delivery] = deliveryRequeued
+		n++
+	}
+	return n
+}
+
+// settle квитирует запрос функцией `f`, если он не был квитирован ранее или возвращен
+// в очередь при остановке сервиса, и помечает его квитированным.
+func (s *Service) settle(delivery *amqp.Delivery, f func() error) error {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	state, ok := s.active[delivery]
+	if ok && state != deliveryActive {
+		return nil
+	}
+	if ok {
+		s.active[delivery] = deliverySettled
+	}
+	return brokerError(ErrAck, s.Name, f())
+}
@@ -0,0 +1,134 @@
+package microservice
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+type testAcknowledger struct {
+	mu      sync.Mutex
+	acked   int
+	nacked  int
+	requeue bool
+}
+
+func (a *testAcknowledger) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked++
+	return nil
+}
+
+func (a *testAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.nacked++
+	a.requeue = requeue
+	return nil
+}
+
+func (a *testAcknowledger) Reject(tag uint64, requeue bool) error {
+	return a.Nack(tag, false, requeue)
+}
+
+func TestShutdownRequeuesUnfinished(t *testing.T) {
+	srv := NewService(testServiceName)
+	ack := &testAcknowledger{}
+	finished := &amqp.Delivery{Acknowledger: ack}
+	unfinished := &amqp.Delivery{Acknowledger: ack}
+	srv.track(finished)
+	srv.track(unfinished)
+	srv.untrack(finished)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := srv.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 1, ack.nacked)
+	assert.True(t, ack.requeue)
+	assert.True(t, srv.isStopping())
+	assert.Equal(t, StateClosed, srv.State())
+
+	// обработчик, завершившийся после возврата запроса в очередь, не квитирует его повторно
+	assert.NoError(t, srv.answerPanic(unfinished, &PanicError{}, "late handler"))
+	assert.False(t, srv.untrack(unfinished))
+	assert.Equal(t, 0, ack.acked)
+	assert.Equal(t, 1, ack.nacked)
+}
+
+func TestShutdownSkipsSettled(t *testing.T) {
+	srv := NewService(testServiceName)
+	ack := &testAcknowledger{}
+	delivery := &amqp.Delivery{Acknowledger: ack}
+	srv.track(delivery)
+	// обработчик квитировал запрос, но еще не завершился (экспорт интервала, метрики)
+	assert.NoError(t, srv.settle(delivery, func() error { return delivery.Ack(false) }))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.NoError(t, srv.Shutdown(ctx))
+	assert.Equal(t, 1, ack.acked)
+	assert.Equal(t, 0, ack.nacked)
+
+	assert.NoError(t, srv.settle(delivery, func() error { return delivery.Ack(false) }))
+	assert.False(t, srv.untrack(delivery))
+	assert.Equal(t, 1, ack.acked)
+}
+
+func TestShutdownDrains(t *testing.T) {
+	srv := NewService(testServiceName)
+	delivery := &amqp.Delivery{Acknowledger: &testAcknowledger{}}
+	srv.track(delivery)
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		srv.untrack(delivery)
+	}()
+	assert.NoError(t, srv.Shutdown(context.Background()))
+}
+
+func TestShutdownUnderLoad(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+	srv := NewService(testServiceName, WithMaxInFlight(4))
+	srv.Handle("work", func(req *Request) (interface{}, error) {
+		time.Sleep(time.Millisecond)
+		return nil, nil
+	})
+	srv.SetTransport(broker)
+	if !assert.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr)) {
+		return
+	}
+	go srv.Run(ctx)
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Transport: broker})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer cl.Close()
+	_, body, _ := CreateCmdRequest("work")
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				corrID, _, _ := CreateCmdRequest("")
+				if cl.RequestE(testServiceName, corrID, body) != nil {
+					return
+				}
+				cl.release(corrID)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, srv.Shutdown(context.Background()))
+	assert.False(t, srv.track(&amqp.Delivery{}))
+	cancel()
+	wg.Wait()
+}
@@ -3,51 +3,123 @@
 package microservice
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/streadway/amqp"
 )
 
 // Publisher ..
 type Publisher struct {
-	conn     *amqp.Connection
-	ch       *amqp.Channel
+	connManager
 	exchType string
 	exchName string
+	metrics  *publisherMetrics
+	tracer   *Tracer
+
+	pendingMu sync.Mutex
+	pending   []confirmation // публикации EmitAsync, ожидающие подтверждения
 }
 
 // Subscriber ..
 type Subscriber struct {
-	conn     *amqp.Connection
-	ch       *amqp.Channel
-	q        amqp.Queue
-	exchType string
-	exchName string
+	connManager
+	q          amqp.Queue
+	exchType   string
+	exchName   string
+	bindings   []binding
+	queueName  string      // имя очереди именованной подписки
+	queueCfg   QueueConfig // параметры очереди именованной подписки
+	retry      RetryPolicy
+	prefetch   int
+	consumeMu  sync.Mutex
+	consuming  bool
+	manualAck  bool // события квитируются обработчиком Consume
+	deliveries chan amqp.Delivery
 }
 
-// NewPublisher создает объект издателя, подключенный к Exchange типа `fanout`.
-func NewPublisher(exchName string) *Publisher {
+// NewPublisher создает объект издателя, подключенный к Exchange типа `fanout` или типа,
+// заданного WithExchangeType.
+func NewPublisher(exchName string, opts ...ExchangeOption) *Publisher {
+	cfg := newExchangeConfig(opts)
 	return &Publisher{
-		exchType: "fanout",
+		exchType: cfg.kind,
 		exchName: exchName,
 	}
 }
 
-// NewSubscriber создает объект подписчика, подключенный к Exchange типа `fanout`.
-func NewSubscriber(exchName string) *Subscriber {
+// NewPublisherFromConfig создает издателя точки обмена PublisherConfig.Exchange с
+// параметрами `cfg` (см. LoadConfig) и подключает его к брокеру. Пустой адрес брокера
+// заменяется на DefaultRabbitMQConnStr.
+func NewPublisherFromConfig(cfg PublisherConfig, opts ...ExchangeOption) (*Publisher, error) {
+	if cfg.URL == "" {
+		cfg.URL = DefaultRabbitMQConnStr
+	}
+	if cfg.Exchange == "" {
+		return nil, fmt.Errorf("%w: empty publisher exchange", ErrInvalidConfig)
+	}
+	if cfg.ConfirmTimeout < 0 {
+		return nil, fmt.Errorf("%w: negative confirm timeout", ErrInvalidConfig)
+	}
+	pub := NewPublisher(cfg.Exchange, opts...)
+	pub.SetTransport(cfg.Transport)
+	pub.EnableConfirms(cfg.ConfirmTimeout)
+	if err := pub.ConnectE(cfg.URL); err != nil {
+		return nil, err
+	}
+	return pub, nil
+}
+
+// NewSubscriber создает объект подписчика, подключенный к Exchange типа `fanout` или типа,
+// заданного WithExchangeType. Очередь подписчика привязывается к Exchange с ключами и
+// правилами, заданными WithBindingKeys и WithHeaderMatch, а при их отсутствии - с пустым
+// ключом.
+func NewSubscriber(exchName string, opts ...ExchangeOption) *Subscriber {
+	cfg := newExchangeConfig(opts)
 	return &Subscriber{
-		exchType: "fanout",
-		exchName: exchName,
+		exchType:   cfg.kind,
+		exchName:   exchName,
+		bindings:   cfg.bindings,
+		queueName:  cfg.subscription,
+		queueCfg:   cfg.queue,
+		retry:      cfg.retry,
+		prefetch:   cfg.prefetch,
+		deliveries: make(chan amqp.Delivery),
+	}
+}
+
+func newExchangeConfig(opts []ExchangeOption) exchangeConfig {
+	cfg := exchangeConfig{kind: amqp.ExchangeFanout, prefetch: DefaultSubscriberPrefetch}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(cfg.bindings) == 0 {
+		cfg.bindings = []binding{{}}
 	}
+	return cfg
 }
 
 // Connect выполняет соединение с брокером и инициалирует Exchange.
-// В случае ошибки процесс завершает свою работу.
+// В случае ошибки подключения работа прекращается (см. ConnectE).
 func (pub *Publisher) Connect(connStr string) {
-	var err error
-	pub.conn, err = amqp.Dial(connStr)
-	FailOnError(err, "Failed to connect to RabbitMQ")
-	pub.ch, err = pub.conn.Channel()
-	FailOnError(err, "Failed to open a channel")
-	err = pub.ch.ExchangeDeclare(
+	FailOnError(pub.ConnectE(connStr), "Failed to connect to RabbitMQ")
+}
+
+// ConnectE выполняет соединение с брокером, инициалирует Exchange и возвращает ошибку
+// подключения.
+// При разрыве соединения подключение восстанавливается автоматически.
+func (pub *Publisher) ConnectE(connStr string) error {
+	if err := (exchangeConfig{kind: pub.exchType}).validate(); err != nil {
+		return err
+	}
+	return pub.connect(connStr, pub.declareTopology)
+}
+
+func (pub *Publisher) declareTopology(ch Channel) error {
+	err := ch.ExchangeDeclare(
 		pub.exchName,
 		pub.exchType,
 		true,
@@ -56,43 +128,126 @@ func (pub *Publisher) Connect(connStr string) {
 		false,
 		nil,
 	)
-	FailOnError(err, "Failed to declare an exchange")
+	return brokerError(ErrDeclare, pub.exchName, err)
 }
 
 // Close освобождает ресурсы Emitter.
 func (pub *Publisher) Close() {
-	pub.ch.Close()
-	pub.conn.Close()
+	pub.close()
 }
 
 // Emit отправляет сообщение подписчикам.
 // contentType = "text/plain"
-func (pub *Publisher) Emit(contentType string, data []byte) error {
-	err := pub.ch.Publish(
-		pub.exchName,
-		"",
-		false,
-		false,
-		amqp.Publishing{
-			ContentType: contentType,
-			Body:        data,
-		})
+// Ключ маршрутизации и заголовки сообщения задаются параметрами `opts`.
+func (pub *Publisher) Emit(contentType string, data []byte, opts ...EmitOption) error {
+	return pub.EmitContext(context.Background(), contentType, data, opts...)
+}
+
+// EmitContext отправляет сообщение подписчикам с контекстом трассировки из `ctx`.
+// В режиме подтверждений (см. EnableConfirms) дожидается подтверждения публикации.
+func (pub *Publisher) EmitContext(
+	ctx context.Context, contentType string, data []byte, opts ...EmitOption) error {
+	c, err := pub.emit(ctx, contentType, data, opts)
+	if err == nil {
+		err = c.wait(ctx)
+	}
+	pub.metrics.observe(pub.exchName, err)
+	return brokerError(ErrPublish, pub.exchName, err)
+}
+
+// emit публикует сообщение и возвращает ожидание его подтверждения.
+func (pub *Publisher) emit(
+	ctx context.Context, contentType string, data []byte, opts []EmitOption) (confirmation, error) {
+	var m emitMessage
+	for _, opt := range opts {
+		opt(&m)
+	}
+	ctx, span := pub.tracer.startSpan(ctx, pub.exchName, SpanProducer)
+	if span != nil && m.key != "" {
+		span.SetAttribute("routing_key", m.key)
+	}
+	c, err := pub.publish(pub.exchName, m.key, false, amqp.Publishing{
+		ContentType: contentType,
+		Headers:     InjectTrace(ctx, m.headers),
+		Body:        data,
+	})
+	finishSpan(span, err)
+	return c, err
+}
+
+// EnableConfirms включает подтверждение брокером публикации сообщений со сроком ожидания
+// `timeout` (см. confirm.go). Должен вызываться до подключения.
+func (pub *Publisher) EnableConfirms(timeout time.Duration) {
+	pub.setConfirmTimeout(timeout)
+}
+
+// EmitAsync отправляет сообщение подписчикам, не дожидаясь подтверждения публикации.
+// Подтверждения накопленных публикаций проверяются методом WaitConfirms.
+func (pub *Publisher) EmitAsync(contentType string, data []byte, opts ...EmitOption) error {
+	c, err := pub.emit(context.Background(), contentType, data, opts)
 	if err != nil {
-		return err
+		pub.metrics.observe(pub.exchName, err)
+		return brokerError(ErrPublish, pub.exchName, err)
 	}
+	pub.pendingMu.Lock()
+	pub.pending = append(pub.pending, c)
+	pub.pendingMu.Unlock()
 	return nil
 }
 
-// Connect выполняет соединение с брокером.
-// Также настраивает Exchange, создает новую очередь и связывает ее с Exchange.
-// В случае ошибки процесс останавливается.
+// WaitConfirms дожидается подтверждения публикаций, выполненных EmitAsync после
+// предыдущего вызова WaitConfirms. Если часть публикаций не подтверждена, возвращается
+// ошибка ErrNotConfirmed с их числом.
+func (pub *Publisher) WaitConfirms(ctx context.Context) error {
+	pub.pendingMu.Lock()
+	pending := pub.pending
+	pub.pending = nil
+	pub.pendingMu.Unlock()
+
+	failed := 0
+	var last error
+	for _, c := range pending {
+		err := c.wait(ctx)
+		pub.metrics.observe(pub.exchName, err)
+		if err != nil {
+			failed++
+			last = err
+		}
+	}
+	if failed > 0 {
+		return brokerError(ErrPublish, pub.exchName,
+			fmt.Errorf("%d of %d messages: %w", failed, len(pending), last))
+	}
+	return nil
+}
+
+// Connect выполняет соединение с брокером аналогично ConnectE.
+// В случае ошибки подключения работа прекращается.
 func (sub *Subscriber) Connect(connStr string) {
-	var err error
-	sub.conn, err = amqp.Dial(connStr)
-	FailOnError(err, "Failed to connect to RabbitMQ")
-	sub.ch, err = sub.conn.Channel()
-	FailOnError(err, "Failed to open a channel")
-	err = sub.ch.ExchangeDeclare(
+	FailOnError(sub.ConnectE(connStr), "Failed to connect to RabbitMQ")
+}
+
+// ConnectE выполняет соединение с брокером и возвращает ошибку подключения.
+// Также настраивает Exchange, создает новую очередь (или очередь именованной подписки,
+// см. WithSubscription) и связывает ее с Exchange.
+// При разрыве соединения подключение, очередь и ее привязка восстанавливаются автоматически.
+func (sub *Subscriber) ConnectE(connStr string) error {
+	cfg := exchangeConfig{
+		kind:         sub.exchType,
+		bindings:     sub.bindings,
+		subscription: sub.queueName,
+		queue:        sub.queueCfg,
+		retry:        sub.retry,
+		prefetch:     sub.prefetch,
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	return sub.connect(connStr, sub.declareTopology)
+}
+
+func (sub *Subscriber) declareTopology(ch Channel) error {
+	err := ch.ExchangeDeclare(
 		sub.exchName,
 		sub.exchType,
 		true,
@@ -101,66 +256,134 @@ func (sub *Subscriber) Connect(connStr string) {
 		false,
 		nil,
 	)
-	FailOnError(err, "Failed to declare an exchange")
-	sub.q, err = sub.ch.QueueDeclare(
-		"",
-		false,
-		false,
-		true,
-		false,
-		nil,
-	)
-	FailOnError(err, "Failed to declare a queue")
-	err = sub.ch.QueueBind(
-		sub.q.Name,
-		"",
-		sub.exchName,
-		false,
-		nil,
-	)
-	FailOnError(err, "Failed to bind a queue")
+	if err != nil {
+		return brokerError(ErrDeclare, sub.exchName, err)
+	}
+	if err = ch.Qos(sub.prefetch, 0, false); err != nil {
+		return brokerError(ErrQos, "", err)
+	}
+	if sub.queueName != "" {
+		sub.q, err = ch.QueueDeclare(
+			sub.queueName,
+			sub.queueCfg.Durable,
+			sub.queueCfg.AutoDelete,
+			sub.queueCfg.Exclusive,
+			false,
+			sub.queueCfg.Table(),
+		)
+	} else {
+		sub.q, err = ch.QueueDeclare(
+			"",
+			false,
+			false,
+			true,
+			false,
+			nil,
+		)
+	}
+	if err != nil {
+		return brokerError(ErrDeclare, sub.queueName, err)
+	}
+	for _, b := range sub.bindings {
+		err = ch.QueueBind(
+			sub.q.Name,
+			b.key,
+			sub.exchName,
+			false,
+			b.args,
+		)
+		if err != nil {
+			return brokerError(ErrBind, sub.q.Name, err)
+		}
+	}
+	return sub.declareRetryQueue(ch)
+}
+
+// consume однократно регистрирует потребителя очереди подписчика с автоматическим
+// (`autoAck`) или ручным квитированием.
+func (sub *Subscriber) consume(autoAck bool) error {
+	sub.consumeMu.Lock()
+	defer sub.consumeMu.Unlock()
+	if sub.consuming {
+		if sub.manualAck == autoAck {
+			return ErrConsumeMode
+		}
+		return nil
+	}
+	err := sub.declare(func(ch Channel) error {
+		msgs, err := ch.Consume(
+			sub.q.Name,
+			"",
+			autoAck,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return brokerError(ErrConsume, sub.q.Name, err)
+		}
+		go sub.forward(msgs, sub.deliveries)
+		return nil
+	})
+	sub.consuming = err == nil
+	sub.manualAck = !autoAck
+	return err
 }
 
 // Close освобождает ресурсы подписчика по завершении его работы.
 func (sub *Subscriber) Close() {
-	sub.ch.Close()
-	sub.conn.Close()
+	sub.close()
 }
 
 // Receive в цикле принимает сообщения от издателя и пересылает их в предоставленный выходной канал.
-// В случае ошибки цикл приема сообщений прерывается.
+// Возврат из метода происходит при закрытии подписчика, а в случае ошибки работа
+// прекращается (см. ReceiveE).
 func (sub *Subscriber) Receive(out chan<- amqp.Delivery) {
-	msgs, err := sub.ch.Consume(
-		sub.q.Name,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	FailOnError(err, "Failed to register a consumer")
-	forever := make(chan bool)
-	go func() {
-		for d := range msgs {
+	if err := sub.ReceiveE(out); !errors.Is(err, ErrClosed) {
+		FailOnError(err, "Failed to register a consumer")
+	}
+}
+
+// ReceiveE в цикле принимает сообщения от издателя и пересылает их в предоставленный
+// выходной канал.
+// Возврат из метода происходит в случае ошибки или при закрытии подписчика (ErrClosed).
+// Сообщения подтверждаются брокеру при получении (для подтверждения после обработки
+// используйте Consume).
+func (sub *Subscriber) ReceiveE(out chan<- amqp.Delivery) error {
+	if err := sub.consume(true); err != nil {
+		return err
+	}
+	for {
+		select {
+		case d := <-sub.deliveries:
 			out <- d
+		case <-sub.done:
+			return ErrClosed
 		}
-	}()
-	<-forever
+	}
 }
 
 // ReceiveOnce выполняет прием одного сообщения.
-// Применяется для тестовых целей.
+// Применяется для тестовых целей. В случае ошибки работа прекращается (см. ReceiveOnceE),
+// а при закрытии подписчика возвращается пустое сообщение.
 func (sub *Subscriber) ReceiveOnce() amqp.Delivery {
-	msgs, err := sub.ch.Consume(
-		sub.q.Name,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	FailOnError(err, "Failed to register a consumer")
-	return <-msgs
+	d, err := sub.ReceiveOnceE()
+	if !errors.Is(err, ErrClosed) {
+		FailOnError(err, "Failed to register a consumer")
+	}
+	return d
+}
+
+// ReceiveOnceE выполняет прием одного сообщения и возвращает ошибку приема.
+func (sub *Subscriber) ReceiveOnceE() (amqp.Delivery, error) {
+	if err := sub.consume(true); err != nil {
+		return amqp.Delivery{}, err
+	}
+	select {
+	case d := <-sub.deliveries:
+		return d, nil
+	case <-sub.done:
+		return amqp.Delivery{}, ErrClosed
+	}
 }
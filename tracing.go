@@ -0,0 +1,358 @@
+// Модуль распределенной трассировки запросов.
+//
+// Контекст трассировки передается в заголовке `traceparent` сообщений AMQP в формате
+// W3C Trace Context:
+//
+//	traceparent: 00-<trace-id>-<span-id>-<flags>
+//
+// Контекст добавляется в сообщения RPCClient, Publisher и ответы Service и извлекается
+// микросервисом при получении запроса (см. ContextFromDelivery). Передача контекста
+// выполняется всегда, а интервалы (Span) создаются компонентами, для которых задан
+// трассировщик (см. EnableTracing). Завершенные интервалы передаются экспортеру
+// (например, WriterExporter для вывода в stdout или файл).
+//
+// Признак выборки (флаг sampled) наследуется от родительского интервала, а новая трасса
+// выбирается всегда. Интервалы трассы, не попавшей в выборку, не экспортируются, но
+// контекст трассировки передается дальше.
+
+package microservice
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// TraceparentHeader - заголовок сообщения с контекстом трассировки.
+const TraceparentHeader = "traceparent"
+
+// ErrInvalidTraceparent возвращается для некорректного значения заголовка traceparent.
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+// TraceID - идентификатор трассы.
+type TraceID [16]byte
+
+// SpanID - идентификатор интервала.
+type SpanID [8]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// IsValid сообщает, что идентификатор не нулевой.
+func (id TraceID) IsValid() bool { return id != TraceID{} }
+
+// IsValid сообщает, что идентификатор не нулевой.
+func (id SpanID) IsValid() bool { return id != SpanID{} }
+
+// MarshalJSON представляет идентификатор шестнадцатеричной строкой.
+func (id TraceID) MarshalJSON() ([]byte, error) { return json.Marshal(id.String()) }
+
+// MarshalJSON представляет идентификатор шестнадцатеричной строкой.
+func (id SpanID) MarshalJSON() ([]byte, error) { return json.Marshal(id.String()) }
+
+// SpanContext описывает контекст трассировки, передаваемый между микросервисами.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+// IsValid сообщает о наличии идентификаторов трассы и интервала.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent возвращает значение заголовка traceparent.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent разбирает значение заголовка traceparent.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" ||
+		(parts[0] == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	var flags [1]byte
+	if err := decodeHex(sc.TraceID[:], parts[1]); err != nil {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if err := decodeHex(sc.SpanID[:], parts[2]); err != nil {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if err := decodeHex(flags[:], parts[3]); err != nil {
+		return sc, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("%w: %q", ErrInvalidTraceparent, s)
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+func decodeHex(dst []byte, s string) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return ErrInvalidTraceparent
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext возвращает контекст, содержащий контекст трассировки `sc`.
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext возвращает контекст трассировки, содержащийся в `ctx`.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+// InjectTrace добавляет контекст трассировки из `ctx` в заголовки сообщения `headers`
+// и возвращает их. При отсутствии заголовков создается новая таблица.
+func InjectTrace(ctx context.Context, headers amqp.Table) amqp.Table {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		return headers
+	}
+	if headers == nil {
+		headers = amqp.Table{}
+	}
+	headers[TraceparentHeader] = sc.Traceparent()
+	return headers
+}
+
+// ExtractTrace извлекает контекст трассировки из заголовков сообщения.
+func ExtractTrace(headers amqp.Table) (SpanContext, bool) {
+	s, ok := headers[TraceparentHeader].(string)
+	if !ok {
+		return SpanContext{}, false
+	}
+	sc, err := ParseTraceparent(s)
+	return sc, err == nil
+}
+
+// ContextFromDelivery возвращает контекст, содержащий контекст трассировки сообщения `d`.
+// При его отсутствии возвращается `ctx`.
+func ContextFromDelivery(ctx context.Context, d *amqp.Delivery) context.Context {
+	if sc, ok := ExtractTrace(d.Headers); ok {
+		return ContextWithSpanContext(ctx, sc)
+	}
+	return ctx
+}
+
+// Виды интервалов.
+const (
+	SpanServer   = "server"   // обработка запроса микросервисом
+	SpanClient   = "client"   // вызов микросервиса
+	SpanProducer = "producer" // публикация события
+)
+
+// Span описывает интервал трассы.
+type Span struct {
+	TraceID    TraceID           `json:"trace_id"`
+	SpanID     SpanID            `json:"span_id"`
+	ParentID   *SpanID           `json:"parent_id,omitempty"`
+	Name       string            `json:"name"`
+	Kind       string            `json:"kind"`
+	Service    string            `json:"service,omitempty"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Error      string            `json:"error,omitempty"`
+
+	tracer  *Tracer
+	sampled bool
+	mu      sync.Mutex
+	ended   bool
+}
+
+// Context возвращает контекст трассировки интервала.
+func (s *Span) Context() SpanContext {
+	return SpanContext{TraceID: s.TraceID, SpanID: s.SpanID, Sampled: s.sampled}
+}
+
+// SetAttribute задает атрибут интервала.
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Attributes == nil {
+		s.Attributes = map[string]string{}
+	}
+	s.Attributes[key] = value
+}
+
+// Finish завершает интервал с ошибкой `err` (nil для успешного завершения) и передает его
+// экспортеру, если трасса попала в выборку. Повторные вызовы игнорируются.
+func (s *Span) Finish(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+	s.mu.Unlock()
+	if exporter := s.tracer.exporter; exporter != nil && s.sampled {
+		if err := exporter.ExportSpan(s); err != nil {
+			log.WithField("context", "Tracing").Warn(err)
+		}
+	}
+}
+
+// Exporter описывает получателя завершенных интервалов.
+type Exporter interface {
+	ExportSpan(span *Span) error
+}
+
+// ExporterFunc позволяет использовать функцию в качестве экспортера.
+type ExporterFunc func(span *Span) error
+
+// ExportSpan передает интервал функции.
+func (f ExporterFunc) ExportSpan(span *Span) error {
+	return f(span)
+}
+
+// Tracer создает интервалы трассы от имени сервиса и передает их экспортеру.
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// NewTracer создает трассировщик интервалов сервиса `service`, передающий завершенные
+// интервалы экспортеру `exporter`.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// Start начинает интервал `name` вида `kind`. Родительский интервал и признак выборки
+// определяются по контексту трассировки `ctx`, а при его отсутствии начинается новая
+// трасса. Возвращается контекст, содержащий контекст трассировки нового интервала.
+func (t *Tracer) Start(ctx context.Context, name, kind string) (context.Context, *Span) {
+	span := &Span{
+		Name:    name,
+		Kind:    kind,
+		Service: t.service,
+		Start:   time.Now(),
+		tracer:  t,
+		sampled: true,
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.TraceID = parent.TraceID
+		span.ParentID = &parent.SpanID
+		span.sampled = parent.Sampled
+	} else {
+		rand.Read(span.TraceID[:])
+	}
+	rand.Read(span.SpanID[:])
+	return ContextWithSpanContext(ctx, span.Context()), span
+}
+
+// startSpan начинает интервал, если трассировщик задан.
+func (t *Tracer) startSpan(ctx context.Context, name, kind string) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	return t.Start(ctx, name, kind)
+}
+
+// finishSpan завершает интервал, начатый startSpan.
+func finishSpan(span *Span, err error) {
+	if span != nil {
+		span.Finish(err)
+	}
+}
+
+// WriterExporter выводит интервалы в формате JSON (по одному в строке).
+type WriterExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter создает экспортер, выводящий интервалы в `w` (например, os.Stdout).
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{w: w}
+}
+
+// NewFileExporter создает экспортер, дописывающий интервалы в файл `path`.
+// Файл закрывается методом Close.
+func NewFileExporter(path string) (*WriterExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterExporter(f), nil
+}
+
+// ExportSpan выводит интервал.
+func (e *WriterExporter) ExportSpan(span *Span) error {
+	span.mu.Lock()
+	data, err := json.Marshal(span)
+	span.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.w.Write(append(data, '\n'))
+	return err
+}
+
+// Close закрывает получателя интервалов, если он поддерживает закрытие.
+func (e *WriterExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// EnableTracing включает создание интервалов обработки команд трассировщиком `t`.
+// Должен вызываться до подключения к брокеру.
+func (s *Service) EnableTracing(t *Tracer) {
+	s.tracer = t
+}
+
+// EnableTracing включает создание интервалов вызовов микросервисов трассировщиком `t`.
+func (cl *RPCClient) EnableTracing(t *Tracer) {
+	cl.instrMu.Lock()
+	defer cl.instrMu.Unlock()
+	cl.tracer = t
+}
+
+func (cl *RPCClient) clientTracer() *Tracer {
+	cl.instrMu.RLock()
+	defer cl.instrMu.RUnlock()
+	return cl.tracer
+}
+
+// EnableTracing включает создание интервалов публикации событий трассировщиком `t`.
+// Должен вызываться до публикации событий.
+func (pub *Publisher) EnableTracing(t *Tracer) {
+	pub.tracer = t
+}
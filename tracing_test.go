@@ -0,0 +1,147 @@
+package microservice
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTraceparent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(tp)
+	require.NoError(t, err)
+	assert.True(t, sc.Sampled)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", sc.TraceID.String())
+	assert.Equal(t, tp, sc.Traceparent())
+
+	for _, s := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceparent(s)
+		assert.ErrorIs(t, err, ErrInvalidTraceparent, s)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	broker := NewMemoryBroker()
+
+	var mu sync.Mutex
+	spans := map[string]*Span{}
+	exporter := ExporterFunc(func(span *Span) error {
+		mu.Lock()
+		defer mu.Unlock()
+		spans[span.Kind] = span
+		return nil
+	})
+
+	srv := NewService(testServiceName)
+	srv.EnableTracing(NewTracer(testServiceName, exporter))
+	srv.SetTransport(broker)
+	require.NoError(t, srv.ConnectToMessageBrokerE(memoryConnStr))
+	go srv.Run(ctx)
+	defer srv.Cleanup()
+
+	pub := NewPublisher("events")
+	pub.EnableTracing(NewTracer(testServiceName, exporter))
+	pub.SetTransport(broker)
+	require.NoError(t, pub.ConnectE(memoryConnStr))
+	defer pub.Close()
+	sub := NewSubscriber("events")
+	sub.SetTransport(broker)
+	require.NoError(t, sub.ConnectE(memoryConnStr))
+	defer sub.Close()
+
+	var handlerCtx SpanContext
+	srv.Handle("emit", func(req *Request) (interface{}, error) {
+		handlerCtx, _ = SpanContextFromContext(req.Context())
+		return nil, pub.EmitContext(req.Context(), "text/plain", []byte("event"))
+	})
+
+	cl, err := NewRPCClientFromConfig(ClientConfig{URL: memoryConnStr, Timeout: time.Second, Transport: broker})
+	require.NoError(t, err)
+	defer cl.Close()
+	cl.EnableTracing(NewTracer("client", exporter))
+
+	_, err = Call[interface{}, interface{}](ctx, cl, testServiceName, "emit", nil)
+	require.NoError(t, err)
+	event, err := sub.ReceiveOnceE()
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(spans) == 3
+	}, time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	client, server, producer := spans[SpanClient], spans[SpanServer], spans[SpanProducer]
+	assert.Nil(t, client.ParentID)
+	assert.Equal(t, client.TraceID, server.TraceID)
+	assert.Equal(t, client.SpanID, *server.ParentID)
+	assert.Equal(t, "emit", server.Name)
+	assert.Equal(t, server.Context(), handlerCtx)
+	assert.Equal(t, server.SpanID, *producer.ParentID)
+
+	eventCtx, ok := ExtractTrace(event.Headers)
+	require.True(t, ok)
+	assert.Equal(t, producer.Context(), eventCtx)
+}
+
+func TestTraceSampling(t *testing.T) {
+	var exported []*Span
+	tracer := NewTracer(testServiceName, ExporterFunc(func(span *Span) error {
+		exported = append(exported, span)
+		return nil
+	}))
+
+	ctx, root := tracer.Start(context.Background(), "root", SpanClient)
+	assert.True(t, root.Context().Sampled)
+	_, child := tracer.Start(ctx, "child", SpanServer)
+	assert.True(t, child.Context().Sampled)
+
+	parent, err := ParseTraceparent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	require.NoError(t, err)
+	ctx, unsampled := tracer.Start(ContextWithSpanContext(context.Background(), parent), "cmd", SpanServer)
+	sc, ok := SpanContextFromContext(ctx)
+	require.True(t, ok)
+	assert.False(t, sc.Sampled)
+	assert.Equal(t, parent.TraceID, sc.TraceID)
+	assert.True(t, strings.HasSuffix(InjectTrace(ctx, nil)[TraceparentHeader].(string), "-00"))
+
+	unsampled.Finish(nil)
+	child.Finish(nil)
+	root.Finish(nil)
+	require.Len(t, exported, 2)
+	assert.Equal(t, "child", exported[0].Name)
+	assert.Equal(t, "root", exported[1].Name)
+}
+
+func TestWriterExporter(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := NewTracer("svc", NewWriterExporter(&buf))
+	_, span := tracer.Start(context.Background(), "cmd", SpanServer)
+	span.SetAttribute("correlation_id", "42")
+	span.Finish(ErrInternal)
+	span.Finish(nil)
+
+	var out map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &out))
+	assert.Equal(t, span.TraceID.String(), out["trace_id"])
+	assert.Equal(t, "cmd", out["name"])
+	assert.Equal(t, "svc", out["service"])
+	assert.Equal(t, ErrInternal.Error(), out["error"])
+	assert.Equal(t, 1, bytes.Count(buf.Bytes(), []byte("\n")))
+}
@@ -0,0 +1,68 @@
+// Модуль абстракции транспорта обмена сообщениями.
+//
+// Компоненты пакета работают с брокером через интерфейсы Transport, Connection и Channel,
+// методы которых повторяют соответствующие методы пакета amqp. Умолчательный транспорт
+// AMQPTransport подключается к RabbitMQ, а MemoryBroker (см. memory.go) реализует брокер
+// внутри процесса для тестирования без внешних зависимостей.
+
+package microservice
+
+import (
+	"github.com/streadway/amqp"
+)
+
+// Transport описывает способ подключения к брокеру сообщений.
+type Transport interface {
+	Dial(url string) (Connection, error)
+}
+
+// Connection описывает подключение к брокеру сообщений.
+type Connection interface {
+	Channel() (Channel, error)
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// Channel описывает канал обмена сообщениями с брокером.
+type Channel interface {
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyClose(receiver chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+// AMQPTransport - транспорт для подключения к RabbitMQ по протоколу AMQP 0-9-1.
+type AMQPTransport struct{}
+
+// DefaultTransport - умолчательный транспорт компонентов пакета.
+var DefaultTransport Transport = AMQPTransport{}
+
+// Dial подключается к брокеру по адресу `url`.
+func (AMQPTransport) Dial(url string) (Connection, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+	return amqpConnection{conn}, nil
+}
+
+// amqpConnection адаптирует amqp.Connection к интерфейсу Connection.
+type amqpConnection struct {
+	*amqp.Connection
+}
+
+func (c amqpConnection) Channel() (Channel, error) {
+	ch, err := c.Connection.Channel()
+	if err != nil {
+		return nil, err
+	}
+	return ch, nil
+}
@@ -13,6 +13,7 @@ package microservice
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"time"
@@ -35,6 +36,7 @@ type WebPoller struct {
 	pending   chan *WebResource
 	Completed chan *WebResource
 	Log       *log.Logger
+	metrics   *webPollerMetrics
 }
 
 // NewWebPoller формирует новый объект WebPoller.
@@ -45,6 +47,15 @@ func NewWebPoller(interval time.Duration) *WebPoller {
 		Completed: make(chan *WebResource)}
 }
 
+// NewWebPollerFromConfig формирует новый объект WebPoller с параметрами `cfg`
+// (см. LoadConfig).
+func NewWebPollerFromConfig(cfg WebPollerConfig) (*WebPoller, error) {
+	if cfg.Interval <= 0 {
+		return nil, fmt.Errorf("%w: web poller interval must be positive", ErrInvalidConfig)
+	}
+	return NewWebPoller(cfg.Interval), nil
+}
+
 // SetPollingInterval динамически изменяет частоту опроса.
 func (wp *WebPoller) SetPollingInterval(interval time.Duration) {
 	wp.ticker.Reset(interval)
@@ -52,7 +63,10 @@ func (wp *WebPoller) SetPollingInterval(interval time.Duration) {
 
 // Add добавляет в очередь обработки новый http-ресурс.
 func (wp *WebPoller) Add(url, method string, headers map[string]string) {
-	wp.Log.Debug(url)
+	if wp.Log != nil {
+		wp.Log.Debug(url)
+	}
+	wp.metrics.queued(1)
 	wp.pending <- &WebResource{URL: url, InHeaders: headers}
 }
 
@@ -106,7 +120,10 @@ func (wp *WebPoller) Start() {
 			case <-tickerChannel:
 				select {
 				case resource := <-wp.pending:
+					wp.metrics.queued(-1)
+					start := time.Now()
 					loadResource(resource)
+					wp.metrics.observe(time.Since(start))
 					wp.Completed <- resource
 				default:
 				}
@@ -1,10 +1,15 @@
 package microservice
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWebPollerLoad(t *testing.T) {
@@ -17,3 +22,17 @@ func TestWebPollerLoad(t *testing.T) {
 		}
 	}
 }
+
+func TestWebPollerWithoutLog(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	defer web.Close()
+
+	poller, err := NewWebPollerFromConfig(WebPollerConfig{Interval: time.Millisecond})
+	require.NoError(t, err)
+	poller.Start()
+	data, err := poller.Load(web.URL, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "ok", string(data))
+}